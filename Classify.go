@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// pageClass is one kind of page a site serves in place of a profile,
+// recognised by words in its title or by markers anywhere in its source.
+type pageClass struct {
+	kind ScrapeErrorKind
+	// Matched against the <title> text, ignoring case
+	titles []string
+	// Matched against the page source as is
+	markers []string
+}
+
+// Work out which of classes a fetched page belongs to, checking them in
+// order. A page matching none of them is taken to be the profile itself.
+func classifyPage(source string, classes []pageClass) (ScrapeErrorKind, bool) {
+	title := strings.ToLower(pageTitle(source))
+	for _, class := range classes {
+		for _, t := range class.titles {
+			if title != "" && strings.Contains(title, strings.ToLower(t)) {
+				return class.kind, true
+			}
+		}
+		for _, marker := range class.markers {
+			if strings.Contains(source, marker) {
+				return class.kind, true
+			}
+		}
+	}
+	return ScrapeFailed, false
+}
+
+// The text of the page's <title> element, or "" if it has none.
+func pageTitle(source string) string {
+	start := strings.Index(source, "<title")
+	if start == -1 {
+		return ""
+	}
+	open := strings.Index(source[start:], ">")
+	if open == -1 {
+		return ""
+	}
+	start += open + 1
+	end := strings.Index(source[start:], "</title>")
+	if end == -1 {
+		return ""
+	}
+	return strings.TrimSpace(html.UnescapeString(source[start : start+end]))
+}
+
+// One line saying how the run's accounts came out, e.g.
+// "Captured 8 of 10 accounts: 8 ok, 1 private, 1 captcha".
+func captureSummary(accounts []*Account) string {
+	ok := 0
+	counts := map[ScrapeErrorKind]int{}
+	for _, account := range accounts {
+		if account.CaptureErr == nil {
+			ok++
+			continue
+		}
+		counts[account.CaptureErr.Kind]++
+	}
+
+	parts := []string{fmt.Sprintf("%d ok", ok)}
+	// Kinds in the order they are declared, so runs read the same way.
+	for kind := ScrapeFailed; kind <= ScrapeRateLimited; kind++ {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	return fmt.Sprintf("Captured %d of %d accounts: %s", ok, len(accounts), strings.Join(parts, ", "))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTikTokPagesAreClassified(t *testing.T) {
+	tests := []struct {
+		fixture string
+		// -1 for a profile that scrapes cleanly
+		want ScrapeErrorKind
+	}{
+		{"alpha.html", -1},
+		{"beta.html", -1},
+		{"gamma.html", ScrapePrivate},
+		// Private, but the counts are still on the page.
+		{"privatestats.html", -1},
+		{"notfound.html", ScrapeNotFound},
+		{"captcha.html", ScrapeCaptcha},
+		{"loginwall.html", ScrapeLoginWall},
+		{"regionblock.html", ScrapeRegionBlocked},
+		{"ratelimited.html", ScrapeRateLimited},
+		{"wentwrong.html", ScrapeBlocked},
+	}
+	driver := newFakeWebDriver()
+	scrapers := newScrapers(&seleniumFetcher{driver: driver, screenshotPath: t.TempDir()})
+	for _, test := range tests {
+		account := tikTokAccount(test.fixture[:len(test.fixture)-len(".html")])
+		driver.serveFixture(t, account.FullURL, "tiktok/"+test.fixture)
+
+		err := captureData(context.Background(), account, scrapers)
+		switch {
+		case test.want == -1 && err != nil:
+			t.Errorf("%s: %v", test.fixture, err)
+		case test.want == -1:
+		case err == nil:
+			t.Errorf("%s scraped cleanly, want %s", test.fixture, test.want)
+		case err.(*ScrapeError).Kind != test.want:
+			t.Errorf("%s: %v, want %s", test.fixture, err, test.want)
+		}
+	}
+}
+
+func TestHTTPStatusIsClassified(t *testing.T) {
+	tests := map[int]ScrapeErrorKind{
+		http.StatusNotFound:                   ScrapeNotFound,
+		http.StatusForbidden:                  ScrapeBlocked,
+		http.StatusTooManyRequests:            ScrapeRateLimited,
+		http.StatusUnavailableForLegalReasons: ScrapeRegionBlocked,
+		http.StatusBadGateway:                 ScrapeFailed,
+	}
+	for status, want := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		account := &Account{AccountName: "alpha", FullURL: server.URL + "/@alpha"}
+		_, err := newHTTPFetcher(nil, newFingerprintSet(defaultConfig())).Fetch(context.Background(), account)
+		server.Close()
+		if se, ok := err.(*ScrapeError); !ok || se.Kind != want {
+			t.Errorf("HTTP %d: %v, want %s", status, err, want)
+		}
+	}
+}
+
+func TestCaptureSummary(t *testing.T) {
+	accounts := []*Account{
+		{AccountName: "alpha"},
+		{AccountName: "beta", CaptureErr: &ScrapeError{Kind: ScrapeCaptcha}},
+		{AccountName: "gamma", CaptureErr: &ScrapeError{Kind: ScrapePrivate}},
+		{AccountName: "delta"},
+		{AccountName: "epsilon", CaptureErr: &ScrapeError{Kind: ScrapeCaptcha}},
+	}
+	want := "Captured 2 of 5 accounts: 2 ok, 1 private, 2 captcha"
+	if got := captureSummary(accounts); got != want {
+		t.Errorf("captureSummary = %q, want %q", got, want)
+	}
+}
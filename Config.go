@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultConfigFile = "config.json"
+
+// Config holds everything that differs between the spreadsheets we update.
+// It is read from a JSON file, then COGSWORTH_* environment variables, then
+// command line flags, each overriding the one before.
+type Config struct {
+	SpreadsheetID    string `json:"spreadsheetId"`
+	URLSpreadsheetID string `json:"urlSpreadsheetId"`
+	URLRange         string `json:"urlRange"`
+	Fetcher          string `json:"fetcher"`
+	SeleniumURL      string `json:"seleniumUrl"`
+	ScreenshotDir    string `json:"screenshotDir"`
+	CredentialsFile  string `json:"credentialsFile"`
+	TokenFile        string `json:"tokenFile"`
+	StateFile        string `json:"stateFile"`
+	// How long reading or updating the sheet may take, retries included.
+	SheetsDeadline Duration `json:"sheetsDeadline"`
+	HistoryFile    string   `json:"historyFile"`
+	// Tab to copy instead of the most recent dated one.
+	FromSheet string `json:"fromSheet"`
+	// Leave a half-built tab in place after a failed run instead of deleting it.
+	KeepPartial bool `json:"keepPartial"`
+	// Where each run's stats go: sheets or xlsx.
+	Output   string `json:"output"`
+	XLSXFile string `json:"xlsxFile"`
+	// CSV with the same number,label,URL columns as urlRange, read instead
+	// of the URL spreadsheet.
+	AccountsFile string `json:"accountsFile"`
+	// Directory each run's captures are exported to as CSV and JSON Lines;
+	// empty turns the export off.
+	ExportDir string `json:"exportDir"`
+	// Print the changes a run would make to the spreadsheet instead of
+	// making them. Usually given as a flag rather than kept in the file.
+	DryRun bool `json:"dryRun"`
+	// Accounts scraped at once, each with its own browser session; 0 uses
+	// every Chrome slot the grid has.
+	Sessions int `json:"sessions"`
+	// How long one account may take before it is given up on.
+	AccountTimeout Duration `json:"accountTimeout"`
+	// Profile fetches allowed per minute to any one domain; 0 is unlimited.
+	RequestsPerMinute int `json:"requestsPerMinute"`
+	// Up to this much extra is added at random to each gap between fetches.
+	FetchJitter Duration `json:"fetchJitter"`
+	// Profile fetches allowed per platform per day; 0 is unlimited.
+	DailyCap int `json:"dailyCap"`
+	// How long to leave a domain alone after it serves a block page.
+	BlockCoolDown Duration `json:"blockCoolDown"`
+	// Proxies profile pages are fetched through, as http://, https:// or
+	// socks5:// URLs with optional user:password; empty connects directly.
+	Proxies []string `json:"proxies"`
+	// When to move on to the next proxy: each session or each account.
+	ProxyRotation string `json:"proxyRotation"`
+	// Block pages in a row after which a proxy is no longer used; 0 keeps
+	// every proxy in service.
+	ProxyMaxBlocks int `json:"proxyMaxBlocks"`
+	// Browser identities to fetch pages with, by name.
+	Fingerprints map[string]Fingerprint `json:"fingerprints"`
+	// Fingerprints handed to each new session in turn.
+	FingerprintRotation []string `json:"fingerprintRotation"`
+	// Fingerprint used for every account on a platform, whatever the
+	// session's, e.g. {"tiktok": "windows"}.
+	PlatformFingerprints map[string]string `json:"platformFingerprints"`
+}
+
+// Duration is a time.Duration written as "90s" or "10m" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+type configSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+// Settings that are switched on with a bare -flag.
+var boolSettings = map[string]bool{"keep-partial": true, "dry-run": true}
+
+var configSettings = []configSetting{
+	{"spreadsheet-id", "COGSWORTH_SPREADSHEET_ID", "spreadsheet the stats are written to",
+		func(cfg *Config, v string) error { cfg.SpreadsheetID = v; return nil }},
+	{"url-spreadsheet-id", "COGSWORTH_URL_SPREADSHEET_ID", "spreadsheet holding the account URLs",
+		func(cfg *Config, v string) error { cfg.URLSpreadsheetID = v; return nil }},
+	{"url-range", "COGSWORTH_URL_RANGE", "A1 range of the account URL table",
+		func(cfg *Config, v string) error { cfg.URLRange = v; return nil }},
+	{"fetcher", "COGSWORTH_FETCHER", "how to load profile pages: selenium or http",
+		func(cfg *Config, v string) error { cfg.Fetcher = v; return nil }},
+	{"selenium-url", "COGSWORTH_SELENIUM_URL", "Selenium grid hub URL",
+		func(cfg *Config, v string) error { cfg.SeleniumURL = v; return nil }},
+	{"screenshot-dir", "COGSWORTH_SCREENSHOT_DIR", "directory screenshots are saved under",
+		func(cfg *Config, v string) error { cfg.ScreenshotDir = v; return nil }},
+	{"credentials", "COGSWORTH_CREDENTIALS", "Google OAuth client secret file",
+		func(cfg *Config, v string) error { cfg.CredentialsFile = v; return nil }},
+	{"token", "COGSWORTH_TOKEN", "cached Google OAuth token file",
+		func(cfg *Config, v string) error { cfg.TokenFile = v; return nil }},
+	{"state", "COGSWORTH_STATE", "save state file tracking the block columns",
+		func(cfg *Config, v string) error { cfg.StateFile = v; return nil }},
+	{"sheets-deadline", "COGSWORTH_SHEETS_DEADLINE", "time allowed for Sheets reads and updates, retries included",
+		func(cfg *Config, v string) (err error) {
+			cfg.SheetsDeadline.Duration, err = time.ParseDuration(v)
+			return err
+		}},
+	{"history", "COGSWORTH_HISTORY", "local database every capture is recorded in",
+		func(cfg *Config, v string) error { cfg.HistoryFile = v; return nil }},
+	{"from-sheet", "COGSWORTH_FROM_SHEET", "title of the tab to copy instead of the latest dated tab",
+		func(cfg *Config, v string) error { cfg.FromSheet = v; return nil }},
+	{"keep-partial", "COGSWORTH_KEEP_PARTIAL", "keep the new tab when a run fails partway, for debugging",
+		func(cfg *Config, v string) (err error) {
+			cfg.KeepPartial, err = strconv.ParseBool(v)
+			return err
+		}},
+	{"output", "COGSWORTH_OUTPUT", "where to write the stats: sheets or xlsx",
+		func(cfg *Config, v string) error { cfg.Output = v; return nil }},
+	{"xlsx-file", "COGSWORTH_XLSX_FILE", "workbook written with --output xlsx",
+		func(cfg *Config, v string) error { cfg.XLSXFile = v; return nil }},
+	{"accounts-file", "COGSWORTH_ACCOUNTS_FILE", "CSV of number,label,URL rows to read instead of the URL spreadsheet",
+		func(cfg *Config, v string) error { cfg.AccountsFile = v; return nil }},
+	{"export-dir", "COGSWORTH_EXPORT_DIR", "directory to write each run's captures to as CSV and JSON Lines",
+		func(cfg *Config, v string) error { cfg.ExportDir = v; return nil }},
+	{"dry-run", "COGSWORTH_DRY_RUN", "print the planned spreadsheet changes without making them",
+		func(cfg *Config, v string) (err error) {
+			cfg.DryRun, err = strconv.ParseBool(v)
+			return err
+		}},
+	{"sessions", "COGSWORTH_SESSIONS", "accounts to scrape at once (default: the grid's chrome capacity, or 1 with the http fetcher)",
+		func(cfg *Config, v string) (err error) {
+			cfg.Sessions, err = strconv.Atoi(v)
+			return err
+		}},
+	{"account-timeout", "COGSWORTH_ACCOUNT_TIMEOUT", "time allowed to scrape one account",
+		func(cfg *Config, v string) (err error) {
+			cfg.AccountTimeout.Duration, err = time.ParseDuration(v)
+			return err
+		}},
+	{"requests-per-minute", "COGSWORTH_REQUESTS_PER_MINUTE", "profile fetches allowed per minute to one domain, 0 for no limit",
+		func(cfg *Config, v string) (err error) {
+			cfg.RequestsPerMinute, err = strconv.Atoi(v)
+			return err
+		}},
+	{"fetch-jitter", "COGSWORTH_FETCH_JITTER", "most random extra delay added between fetches",
+		func(cfg *Config, v string) (err error) {
+			cfg.FetchJitter.Duration, err = time.ParseDuration(v)
+			return err
+		}},
+	{"daily-cap", "COGSWORTH_DAILY_CAP", "profile fetches allowed per platform per day, 0 for no limit",
+		func(cfg *Config, v string) (err error) {
+			cfg.DailyCap, err = strconv.Atoi(v)
+			return err
+		}},
+	{"block-cool-down", "COGSWORTH_BLOCK_COOL_DOWN", "pause for a domain after it serves a block page or captcha",
+		func(cfg *Config, v string) (err error) {
+			cfg.BlockCoolDown.Duration, err = time.ParseDuration(v)
+			return err
+		}},
+	{"proxies", "COGSWORTH_PROXIES", "comma-separated proxy URLs to fetch profiles through",
+		func(cfg *Config, v string) error {
+			cfg.Proxies = nil
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					cfg.Proxies = append(cfg.Proxies, p)
+				}
+			}
+			return nil
+		}},
+	{"proxy-rotation", "COGSWORTH_PROXY_ROTATION", "when to switch proxy: session or account",
+		func(cfg *Config, v string) error { cfg.ProxyRotation = v; return nil }},
+	{"proxy-max-blocks", "COGSWORTH_PROXY_MAX_BLOCKS", "block pages in a row that retire a proxy, 0 to never retire one",
+		func(cfg *Config, v string) (err error) {
+			cfg.ProxyMaxBlocks, err = strconv.Atoi(v)
+			return err
+		}},
+	{"fingerprint-rotation", "COGSWORTH_FINGERPRINT_ROTATION", "comma-separated fingerprint names handed to sessions in turn",
+		func(cfg *Config, v string) error {
+			cfg.FingerprintRotation = nil
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					cfg.FingerprintRotation = append(cfg.FingerprintRotation, name)
+				}
+			}
+			return nil
+		}},
+	{"platform-fingerprints", "COGSWORTH_PLATFORM_FINGERPRINTS", "platform=fingerprint pairs, comma-separated, pinning a platform to one fingerprint",
+		func(cfg *Config, v string) error {
+			cfg.PlatformFingerprints = map[string]string{}
+			for _, pair := range strings.Split(v, ",") {
+				if pair = strings.TrimSpace(pair); pair == "" {
+					continue
+				}
+				parts := strings.SplitN(pair, "=", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("%q is not platform=fingerprint", pair)
+				}
+				cfg.PlatformFingerprints[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+			return nil
+		}},
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		URLRange:        "TikTok URLs!A1:C256",
+		Fetcher:         "selenium",
+		SeleniumURL:     "http://192.168.1.3:4444/wd/hub",
+		ScreenshotDir:   "screenshots",
+		CredentialsFile: "credentials.json",
+		TokenFile:       "token.json",
+		StateFile:       "saveState.json",
+		SheetsDeadline:  Duration{10 * time.Minute},
+		HistoryFile:     "history.db",
+		Output:          "sheets",
+		XLSXFile:        "cogsworth.xlsx",
+		AccountTimeout:  Duration{2 * time.Minute},
+		// About what TikTok tolerates from one address before it challenges.
+		RequestsPerMinute: 12,
+		FetchJitter:       Duration{3 * time.Second},
+		BlockCoolDown:     Duration{5 * time.Minute},
+		ProxyRotation:     "session",
+		ProxyMaxBlocks:    3,
+		Fingerprints: map[string]Fingerprint{
+			defaultFingerprintName: defaultFingerprint,
+		},
+		FingerprintRotation:  []string{defaultFingerprintName},
+		PlatformFingerprints: map[string]string{},
+	}
+}
+
+// Load the configuration from file, environment and args, returning the
+// arguments left after the flags. Commands register their own flags on fs
+// first. Call validate before a full run.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, []string, error) {
+	configFile := fs.String("config", "", "JSON config file (default $COGSWORTH_CONFIG or "+defaultConfigFile+")")
+	for _, setting := range configSettings {
+		if boolSettings[setting.flag] {
+			fs.Bool(setting.flag, false, setting.usage+" ($"+setting.env+")")
+		} else {
+			fs.String(setting.flag, "", setting.usage+" ($"+setting.env+")")
+		}
+	}
+	fs.Parse(args)
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("COGSWORTH_CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigFile
+	}
+
+	cfg := defaultConfig()
+	if err := cfg.readFile(path); err != nil {
+		if explicit || !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+
+	for _, setting := range configSettings {
+		if value, ok := os.LookupEnv(setting.env); ok {
+			if err := setting.set(cfg, value); err != nil {
+				return nil, nil, fmt.Errorf("$%s: %v", setting.env, err)
+			}
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, setting := range configSettings {
+			if setting.flag == f.Name && flagErr == nil {
+				if err := setting.set(cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Check the whole config and report every problem at once.
+func (cfg *Config) validate() error {
+	var problems []string
+	switch cfg.Output {
+	case "sheets":
+		if cfg.SpreadsheetID == "" {
+			problems = append(problems, "spreadsheetId is required")
+		}
+	case "xlsx":
+		if cfg.XLSXFile == "" {
+			problems = append(problems, "xlsxFile is required with the xlsx output")
+		}
+		if cfg.AccountsFile == "" {
+			problems = append(problems, "accountsFile is required with the xlsx output")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("output %q must be sheets or xlsx", cfg.Output))
+	}
+	if cfg.AccountsFile != "" {
+		if _, err := os.Stat(cfg.AccountsFile); err != nil {
+			problems = append(problems, fmt.Sprintf("accountsFile: %v", err))
+		}
+	} else {
+		if cfg.URLSpreadsheetID == "" {
+			problems = append(problems, "urlSpreadsheetId is required")
+		}
+		if !strings.Contains(cfg.URLRange, "!") {
+			problems = append(problems, fmt.Sprintf("urlRange %q must be of the form Sheet!A1:C256", cfg.URLRange))
+		}
+	}
+	switch cfg.Fetcher {
+	case "selenium":
+		if u, err := url.Parse(cfg.SeleniumURL); err != nil || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("seleniumUrl %q is not a valid URL", cfg.SeleniumURL))
+		}
+		if cfg.ScreenshotDir == "" {
+			problems = append(problems, "screenshotDir is required with the selenium fetcher")
+		}
+	case "http":
+	default:
+		problems = append(problems, fmt.Sprintf("fetcher %q must be selenium or http", cfg.Fetcher))
+	}
+	if cfg.usesSheets() {
+		if _, err := os.Stat(cfg.CredentialsFile); err != nil {
+			problems = append(problems, fmt.Sprintf("credentialsFile: %v", err))
+		}
+		if cfg.TokenFile == "" {
+			problems = append(problems, "tokenFile is required")
+		}
+	}
+	if cfg.Output == "sheets" && cfg.StateFile == "" {
+		problems = append(problems, "stateFile is required")
+	}
+	if cfg.HistoryFile == "" {
+		problems = append(problems, "historyFile is required")
+	}
+	if cfg.SheetsDeadline.Duration <= 0 {
+		problems = append(problems, "sheetsDeadline must be positive")
+	}
+	if cfg.Sessions < 0 {
+		problems = append(problems, "sessions cannot be negative")
+	}
+	if cfg.AccountTimeout.Duration <= 0 {
+		problems = append(problems, "accountTimeout must be positive")
+	}
+	if cfg.RequestsPerMinute < 0 || cfg.DailyCap < 0 {
+		problems = append(problems, "requestsPerMinute and dailyCap cannot be negative")
+	}
+	if cfg.FetchJitter.Duration < 0 || cfg.BlockCoolDown.Duration < 0 {
+		problems = append(problems, "fetchJitter and blockCoolDown cannot be negative")
+	}
+	for i, raw := range cfg.Proxies {
+		u, err := parseProxy(raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("proxies[%d]: %v", i, err))
+		} else if u.Scheme == "socks5" && u.User != nil && cfg.Fetcher == "selenium" {
+			problems = append(problems, fmt.Sprintf("proxies[%d]: Chrome cannot log in to SOCKS proxies", i))
+		}
+	}
+	if cfg.ProxyRotation != "session" && cfg.ProxyRotation != "account" {
+		problems = append(problems, fmt.Sprintf("proxyRotation %q must be session or account", cfg.ProxyRotation))
+	}
+	if cfg.ProxyMaxBlocks < 0 {
+		problems = append(problems, "proxyMaxBlocks cannot be negative")
+	}
+	var names []string
+	for name := range cfg.Fingerprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fp := cfg.Fingerprints[name]
+		if err := fp.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("fingerprints.%s: %v", name, err))
+		}
+	}
+	if len(cfg.FingerprintRotation) == 0 {
+		problems = append(problems, "fingerprintRotation must name at least one fingerprint")
+	}
+	for _, name := range cfg.FingerprintRotation {
+		if _, ok := cfg.Fingerprints[name]; !ok {
+			problems = append(problems, fmt.Sprintf("fingerprintRotation: no fingerprint named %q", name))
+		}
+	}
+	var platforms []string
+	for platform := range cfg.PlatformFingerprints {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	for _, platform := range platforms {
+		name := cfg.PlatformFingerprints[platform]
+		if _, ok := scraperRegistry[platform]; !ok {
+			problems = append(problems, fmt.Sprintf("platformFingerprints: no scraper for platform %q", platform))
+		}
+		if _, ok := cfg.Fingerprints[name]; !ok {
+			problems = append(problems, fmt.Sprintf("platformFingerprints.%s: no fingerprint named %q", platform, name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Whether a run needs the Google Sheets API at all.
+func (cfg *Config) usesSheets() bool {
+	return cfg.Output == "sheets" || cfg.AccountsFile == ""
+}
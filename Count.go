@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CountLocale says how a site writes decimal points and thousands separators.
+type CountLocale struct {
+	Decimal rune
+	Group   rune
+}
+
+var (
+	// 1,234 and 1.2M
+	LocaleEnglish = CountLocale{Decimal: '.', Group: ','}
+	// 1.234 and 1,2M
+	LocaleGerman = CountLocale{Decimal: ',', Group: '.'}
+	// 1 234 and 1,2M
+	LocaleFrench = CountLocale{Decimal: ',', Group: ' '}
+)
+
+// Multipliers for the abbreviations social sites put after a count.
+var countSuffixes = map[rune]int64{
+	'K': 1e3,
+	'M': 1e6,
+	'B': 1e9,
+}
+
+// ParseCount reads a count as a profile page displays it, such as "12,345",
+// "45.3K" or "1,2M", and returns the exact number it stands for. Anything
+// that is not a whole, non-negative count is an error rather than 0.
+func ParseCount(count string, locale CountLocale) (int64, error) {
+	s := strings.TrimFunc(count, unicode.IsSpace)
+	if s == "" {
+		return 0, fmt.Errorf("empty count %q", count)
+	}
+
+	multiplier := int64(1)
+	last, size := utf8.DecodeLastRuneInString(s)
+	if m, ok := countSuffixes[unicode.ToUpper(last)]; ok {
+		multiplier = m
+		s = strings.TrimRightFunc(s[:len(s)-size], unicode.IsSpace)
+	}
+
+	whole, fraction := s, ""
+	if i := strings.IndexRune(s, locale.Decimal); i != -1 {
+		whole, fraction = s[:i], s[i+utf8.RuneLen(locale.Decimal):]
+		if fraction == "" || !allDigits(fraction) {
+			return 0, fmt.Errorf("bad decimal part in count %q", count)
+		}
+	}
+	whole, err := ungroup(whole, locale.Group)
+	if err != nil {
+		return 0, fmt.Errorf("count %q: %v", count, err)
+	}
+
+	// whole.fraction * multiplier, kept in integers so nothing is rounded.
+	digits := whole + fraction
+	if len(digits) > 18 {
+		return 0, fmt.Errorf("count %q is too large", count)
+	}
+	var n int64
+	for _, digit := range digits {
+		n = n*10 + int64(digit-'0')
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("count %q is too large", count)
+	}
+	n *= multiplier
+	scale := int64(math.Pow10(len(fraction)))
+	if n%scale != 0 {
+		return 0, fmt.Errorf("count %q is not a whole number", count)
+	}
+	return n / scale, nil
+}
+
+// Strip thousands separators from s, checking they fall every three digits.
+// A space separator also matches the no-break spaces some locales use.
+func ungroup(s string, group rune) (string, error) {
+	isGroup := func(r rune) bool {
+		return r == group || (unicode.IsSpace(group) && unicode.IsSpace(r))
+	}
+	var groups []string
+	start := 0
+	for i, r := range s {
+		if isGroup(r) {
+			groups = append(groups, s[start:i])
+			start = i + utf8.RuneLen(r)
+		}
+	}
+	groups = append(groups, s[start:])
+
+	for i, g := range groups {
+		if !allDigits(g) {
+			return "", fmt.Errorf("unexpected characters in %q", s)
+		}
+		if len(groups) > 1 && ((i == 0 && len(g) > 3) || (i > 0 && len(g) != 3)) {
+			return "", fmt.Errorf("misplaced separator in %q", s)
+		}
+	}
+	return strings.Join(groups, ""), nil
+}
+
+func allDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Converter is one step of a suffix style: counts of at least Divider are
+// shown divided by it with Suffix after them.
+type Converter struct {
+	Divider int64
+	Suffix  string
+}
+
+// CountStyle picks the suffixes FormatCount abbreviates with.
+type CountStyle int
+
+const (
+	// 1.2K, 3.4M, 5B, as social sites show counts
+	StyleSocial CountStyle = iota
+	// 1.2k, 3.4M, 5G, ... up to E
+	StyleSI
+)
+
+var conversionMatrix = map[CountStyle][]Converter{
+	StyleSocial: {
+		{Divider: 1e3, Suffix: "K"},
+		{Divider: 1e6, Suffix: "M"},
+		{Divider: 1e9, Suffix: "B"},
+		{Divider: 1e12, Suffix: "T"},
+	},
+	StyleSI: {
+		{Divider: 1e3, Suffix: "k"},
+		{Divider: 1e6, Suffix: "M"},
+		{Divider: 1e9, Suffix: "G"},
+		{Divider: 1e12, Suffix: "T"},
+		{Divider: 1e15, Suffix: "P"},
+		{Divider: 1e18, Suffix: "E"},
+	},
+}
+
+// FormatCount abbreviates n with the largest suffix of style that fits, e.g.
+// 1234567 as "1.23M" with a precision of 2. Digits past precision are cut
+// off rather than rounded, the way the sites do it, so 999999 never shows
+// as "1000K"; trailing zeros are dropped. Negative counts keep their "-".
+func FormatCount(n int64, precision int, style CountStyle) string {
+	sign := ""
+	abs := uint64(n)
+	if n < 0 {
+		sign = "-"
+		abs = uint64(-n)
+	}
+	converters := conversionMatrix[style]
+	for i := len(converters) - 1; i >= 0; i-- {
+		divider := uint64(converters[i].Divider)
+		if abs < divider {
+			continue
+		}
+		formatted := strconv.FormatUint(abs/divider, 10)
+		// The remainder padded to as many digits as the divider has zeros.
+		fraction := strconv.FormatUint(abs%divider+divider, 10)[1:]
+		if precision < 0 {
+			fraction = ""
+		} else if precision < len(fraction) {
+			fraction = fraction[:precision]
+		}
+		if fraction = strings.TrimRight(fraction, "0"); fraction != "" {
+			formatted += "." + fraction
+		}
+		return sign + formatted + converters[i].Suffix
+	}
+	return sign + strconv.FormatUint(abs, 10)
+}
+
+// FormatDelta is FormatCount for a change, with "+" in front of increases.
+func FormatDelta(n int64, precision int, style CountStyle) string {
+	if n > 0 {
+		return "+" + FormatCount(n, precision, style)
+	}
+	return FormatCount(n, precision, style)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestParseCount(t *testing.T) {
+	tests := []struct {
+		count  string
+		locale CountLocale
+		want   int64
+	}{
+		{"1.2M", LocaleEnglish, 1200000},
+		{"45.3K", LocaleEnglish, 45300},
+		{"12,345", LocaleEnglish, 12345},
+		{"1,234,567", LocaleEnglish, 1234567},
+		{"1.2b", LocaleEnglish, 1200000000},
+		{"999", LocaleEnglish, 999},
+		{"0", LocaleEnglish, 0},
+		{"  45.3 K\n", LocaleEnglish, 45300},
+		{"1,2M", LocaleGerman, 1200000},
+		{"12.345", LocaleGerman, 12345},
+		{"1,2M", LocaleFrench, 1200000},
+		{"12 345", LocaleFrench, 12345},
+		// Sites format with a no-break space.
+		{"12\u00a0345", LocaleFrench, 12345},
+	}
+	for _, test := range tests {
+		got, err := ParseCount(test.count, test.locale)
+		if err != nil {
+			t.Errorf("ParseCount(%q): %v", test.count, err)
+		} else if got != test.want {
+			t.Errorf("ParseCount(%q) = %d, want %d", test.count, got, test.want)
+		}
+	}
+}
+
+func TestParseCountRejects(t *testing.T) {
+	tests := []struct {
+		count  string
+		locale CountLocale
+	}{
+		{"", LocaleEnglish},
+		{"   ", LocaleEnglish},
+		{"K", LocaleEnglish},
+		{"followers", LocaleEnglish},
+		{"-5", LocaleEnglish},
+		{"1.2.3", LocaleEnglish},
+		{"1.", LocaleEnglish},
+		{"12,34", LocaleEnglish},
+		{"1.5", LocaleEnglish},
+		{"1.2345K", LocaleEnglish},
+		{"1.2X", LocaleEnglish},
+		{"99999999999999999999", LocaleEnglish},
+		{"9999999999B", LocaleEnglish},
+		// An English count read with the German locale is a bad grouping.
+		{"1.2M", LocaleGerman},
+	}
+	for _, test := range tests {
+		if got, err := ParseCount(test.count, test.locale); err == nil {
+			t.Errorf("ParseCount(%q) = %d, want an error", test.count, got)
+		}
+	}
+}
+
+// ParseCount must never panic, and whatever it accepts must come back out of
+// FormatCount and the plain digits unchanged.
+func FuzzParseCount(f *testing.F) {
+	for _, seed := range []string{"1.2M", "45.3K", "12,345", "1,2M", "1.2b", " 7 ", "", "garbage", "12\u00a0345"} {
+		f.Add(seed)
+	}
+	locales := []CountLocale{LocaleEnglish, LocaleGerman, LocaleFrench}
+	f.Fuzz(func(t *testing.T, count string) {
+		for _, locale := range locales {
+			n, err := ParseCount(count, locale)
+			if err != nil {
+				continue
+			}
+			if n < 0 {
+				t.Fatalf("ParseCount(%q) = %d", count, n)
+			}
+			if back, err := ParseCount(strconv.FormatInt(n, 10), locale); err != nil || back != n {
+				t.Fatalf("ParseCount(%q) = %d, which reads back as %d, %v", count, n, back, err)
+			}
+			// ParseCount stops at B, so trillions do not round-trip.
+			if n < 1e12 {
+				formatted := FormatCount(n, 18, StyleSocial)
+				if back, err := ParseCount(formatted, LocaleEnglish); err != nil || back != n {
+					t.Fatalf("ParseCount(%q) = %d, formatted as %q, which reads back as %d, %v", count, n, formatted, back, err)
+				}
+			}
+		}
+	})
+}
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		n         int64
+		precision int
+		style     CountStyle
+		want      string
+	}{
+		{0, 1, StyleSocial, "0"},
+		{999, 1, StyleSocial, "999"},
+		{1000, 1, StyleSocial, "1K"},
+		{1234567, 2, StyleSocial, "1.23M"},
+		{1234567, 0, StyleSocial, "1M"},
+		{1234567, -1, StyleSocial, "1M"},
+		{1234567, 6, StyleSocial, "1.234567M"},
+		// Cut off rather than rounded up into the next suffix.
+		{999999, 1, StyleSocial, "999.9K"},
+		{999999, 3, StyleSocial, "999.999K"},
+		{1050, 1, StyleSocial, "1K"},
+		{1200000000, 1, StyleSocial, "1.2B"},
+		{1200, 1, StyleSI, "1.2k"},
+		{1200000, 1, StyleSI, "1.2M"},
+		{1200000000, 1, StyleSI, "1.2G"},
+		{-45300, 1, StyleSocial, "-45.3K"},
+		{-999, 1, StyleSocial, "-999"},
+		{math.MaxInt64, 2, StyleSocial, "9223372.03T"},
+		{math.MaxInt64, 2, StyleSI, "9.22E"},
+		{math.MinInt64, 2, StyleSI, "-9.22E"},
+	}
+	for _, test := range tests {
+		if got := FormatCount(test.n, test.precision, test.style); got != test.want {
+			t.Errorf("FormatCount(%d, %d, %d) = %q, want %q", test.n, test.precision, test.style, got, test.want)
+		}
+	}
+}
+
+func TestFormatDelta(t *testing.T) {
+	for n, want := range map[int64]string{500: "+500", 1500: "+1.5K", 0: "0", -1500: "-1.5K"} {
+		if got := FormatDelta(n, 1, StyleSocial); got != want {
+			t.Errorf("FormatDelta(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	// Excel Functions
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+// Headers of the delta block, the columns right of every dated block in both
+// the follower and likes sections. The first one is how later runs find it.
+var deltaHeaders = []string{"Change (week)", "Change % (week)", "Change (4 weeks)", "Change % (4 weeks)"}
+
+// Change is how much a count moved between an earlier capture and this one.
+type Change struct {
+	Absolute int64
+	// Percent of the earlier value; 0 when the earlier value was 0.
+	Percent float64
+	Valid   bool
+}
+
+// Changes covers both counts we track.
+type Changes struct {
+	Followers Change
+	Likes     Change
+}
+
+func newChange(earlier int64, now int64) Change {
+	change := Change{Absolute: now - earlier, Valid: true}
+	if earlier != 0 {
+		change.Percent = float64(now-earlier) / float64(earlier) * 100
+	}
+	return change
+}
+
+// Cell values for the absolute and percentage columns, blank when unknown
+// so last week's change copied along with the tab does not linger.
+func (c Change) cells() []interface{} {
+	if !c.Valid {
+		return []interface{}{"", ""}
+	}
+	return []interface{}{c.Absolute, strconv.FormatFloat(c.Percent, 'f', 2, 64) + "%"}
+}
+
+// Fill in each account's change against the previous run and against the run
+// four weeks ago, using the last good capture recorded before the start of
+// those days. Accounts that failed this run, or have no earlier capture, are
+// left without a change.
+func computeChanges(history *HistoryStore, accounts []*Account) error {
+	for _, account := range accounts {
+		if account.CaptureErr != nil {
+			continue
+		}
+		y, m, d := account.CapturedAt.Date()
+		today := time.Date(y, m, d, 0, 0, 0, 0, account.CapturedAt.Location())
+		platform, _ := platformForURL(account.FullURL)
+
+		if previous, ok, err := lastGoodCapture(history, platform, account.AccountName, today); err != nil {
+			return err
+		} else if ok {
+			account.WeekChange = Changes{
+				Followers: newChange(previous.Followers, int64(account.Followers)),
+				Likes:     newChange(previous.Likes, int64(account.Likes)),
+			}
+		}
+		if earlier, ok, err := lastGoodCapture(history, platform, account.AccountName, today.AddDate(0, 0, -27)); err != nil {
+			return err
+		} else if ok {
+			account.FourWeekChange = Changes{
+				Followers: newChange(earlier.Followers, int64(account.Followers)),
+				Likes:     newChange(earlier.Likes, int64(account.Likes)),
+			}
+		}
+
+		if account.WeekChange.Followers.Valid {
+			account.Difference = FormatDelta(account.WeekChange.Followers.Absolute, 1, StyleSocial)
+		}
+	}
+	return nil
+}
+
+// The most recent capture without an error from before the given time.
+func lastGoodCapture(history *HistoryStore, platform string, account string, before time.Time) (Capture, bool, error) {
+	captures, err := history.History(platform, account, time.Time{}, before)
+	if err != nil {
+		return Capture{}, false, err
+	}
+	for i := len(captures) - 1; i >= 0; i-- {
+		if captures[i].Status == "" {
+			return captures[i], true, nil
+		}
+	}
+	return Capture{}, false, nil
+}
+
+// Header and per-account cells for the delta block starting at the 0-based
+// column startColumn, matched to the label rows read back from the sheet.
+func changeValues(sheetTitle string, startColumn int64, upperHeaderRowNumber int64, lowerHeaderRowNumber int64, followerRows [][]interface{}, likeRows [][]interface{}, accounts []*Account) []*sheets.ValueRange {
+	headers := make([]interface{}, len(deltaHeaders))
+	for i, header := range deltaHeaders {
+		headers[i] = header
+	}
+	data := []*sheets.ValueRange{
+		rowValues(sheetTitle, startColumn, upperHeaderRowNumber, headers),
+		rowValues(sheetTitle, startColumn, lowerHeaderRowNumber, headers),
+	}
+	for i, row := range followerRows {
+		for _, account := range accounts {
+			if len(row) > 0 && account.Platform == row[0] {
+				cells := append(account.WeekChange.Followers.cells(), account.FourWeekChange.Followers.cells()...)
+				data = append(data, rowValues(sheetTitle, startColumn, upperHeaderRowNumber+1+int64(i), cells))
+			}
+		}
+	}
+	for i, row := range likeRows {
+		for _, account := range accounts {
+			if len(row) > 0 && account.Platform == row[0] {
+				cells := append(account.WeekChange.Likes.cells(), account.FourWeekChange.Likes.cells()...)
+				data = append(data, rowValues(sheetTitle, startColumn, lowerHeaderRowNumber+1+int64(i), cells))
+			}
+		}
+	}
+	return data
+}
+
+// Cells written left to right along one row from the 0-based column start.
+func rowValues(sheetTitle string, start int64, row int64, cells []interface{}) *sheets.ValueRange {
+	first, _ := excelize.ColumnNumberToName(int(start) + 1)
+	last, _ := excelize.ColumnNumberToName(int(start) + len(cells))
+	return &sheets.ValueRange{
+		Range:          fmt.Sprintf("%s!%s%d:%s%d", sheetTitle, first, row, last, row),
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{cells},
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// exportRecord is one account's line in a run's CSV and JSON Lines exports.
+type exportRecord struct {
+	Account   string `json:"account"`
+	Platform  string `json:"platform"`
+	URL       string `json:"url"`
+	Followers int64  `json:"followers"`
+	Likes     int64  `json:"likes"`
+	// Week-over-week follower change as the sheet's readers would write
+	// it, e.g. "+1.2K"; empty when there is nothing to compare with
+	FollowerChange string    `json:"followerChange,omitempty"`
+	CapturedAt     time.Time `json:"capturedAt"`
+	ScreenshotPath string    `json:"screenshotPath,omitempty"`
+	Status         string    `json:"status,omitempty"`
+}
+
+var exportHeader = []string{"account", "platform", "url", "followers", "likes", "follower_change", "captured_at", "screenshot_path", "status"}
+
+func exportFromAccount(account *Account) exportRecord {
+	platform, _ := platformForURL(account.FullURL)
+	record := exportRecord{
+		Account:        account.AccountName,
+		Platform:       platform,
+		URL:            account.FullURL,
+		Followers:      int64(account.Followers),
+		Likes:          int64(account.Likes),
+		FollowerChange: account.Difference,
+		CapturedAt:     account.CapturedAt,
+		ScreenshotPath: account.ScreenshotPath,
+	}
+	if account.CaptureErr != nil {
+		record.Status = account.CaptureErr.Kind.String()
+	}
+	return record
+}
+
+// Write the run's captures to dir as cogsworth-<date>.csv and .jsonl, one
+// line per account. A rerun on the same day replaces that day's files.
+func exportAccounts(dir string, runDate time.Time, accounts []*Account) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	records := make([]exportRecord, len(accounts))
+	for i, account := range accounts {
+		records[i] = exportFromAccount(account)
+	}
+	base := filepath.Join(dir, "cogsworth-"+runDate.Format("2006-01-02"))
+	if err := writeExportCSV(base+".csv", records); err != nil {
+		return err
+	}
+	return writeExportJSONL(base+".jsonl", records)
+}
+
+func writeExportCSV(path string, records []exportRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(f)
+	writer.Write(exportHeader)
+	for _, r := range records {
+		writer.Write([]string{
+			r.Account,
+			r.Platform,
+			r.URL,
+			strconv.FormatInt(r.Followers, 10),
+			strconv.FormatInt(r.Likes, 10),
+			r.FollowerChange,
+			r.CapturedAt.Format(time.RFC3339),
+			r.ScreenshotPath,
+			r.Status,
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeExportJSONL(path string, records []exportRecord) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportCarriesFollowerChange(t *testing.T) {
+	accounts := []*Account{
+		{AccountName: "alpha", FullURL: "https://www.tiktok.com/@alpha", Followers: 1500, CapturedAt: testRunDate, Difference: "+500"},
+		{AccountName: "gamma", FullURL: "https://www.tiktok.com/@gamma", CapturedAt: testRunDate, CaptureErr: &ScrapeError{Kind: ScrapePrivate}},
+	}
+	dir := t.TempDir()
+	if err := exportAccounts(dir, testRunDate, accounts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "cogsworth-2026-10-18.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCells(t, "header", rows[0][3:6], "followers", "likes", "follower_change")
+	expectCells(t, "alpha", rows[1][3:6], "1500", "0", "+500")
+	expectCells(t, "gamma", rows[2][3:6], "0", "0", "")
+}
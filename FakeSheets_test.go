@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+
+	// Excel Functions
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+// fakeSheets is an in-memory stand-in for the parts of the Sheets v4 API the
+// tool uses. It runs behind httptest so the real client library talks to it,
+// and keeps enough state (tabs, cells, hidden columns) to check a run's
+// results cell by cell.
+type fakeSheets struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu           sync.Mutex
+	spreadsheets map[string]*fakeSpreadsheet
+	// When set, a batchUpdate containing a request it returns true for fails
+	// with a 400 and changes nothing.
+	failRequest func(r *sheets.Request) bool
+	// When set, a batchUpdate containing a request it returns true for is
+	// applied but answered with a 503, as when the response is lost.
+	loseResponse func(r *sheets.Request) bool
+}
+
+type fakeSpreadsheet struct {
+	// In index order
+	tabs   []*fakeTab
+	nextID int64
+}
+
+type fakeTab struct {
+	id    int64
+	title string
+	// Cell values by 0-based row then column
+	cells  [][]interface{}
+	hidden map[int64]bool
+}
+
+func newFakeSheets(t *testing.T) *fakeSheets {
+	f := &fakeSheets{t: t, spreadsheets: map[string]*fakeSpreadsheet{}}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// A Sheets client pointed at the fake.
+func (f *fakeSheets) service() *sheets.Service {
+	srv, err := sheets.New(f.server.Client())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	srv.BasePath = f.server.URL + "/"
+	return srv
+}
+
+// Add a tab holding rows, starting from A1, to the given spreadsheet.
+func (f *fakeSheets) addTab(spreadSheetID string, title string, rows [][]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ss := f.spreadsheets[spreadSheetID]
+	if ss == nil {
+		ss = &fakeSpreadsheet{nextID: 100}
+		f.spreadsheets[spreadSheetID] = ss
+	}
+	tab := &fakeTab{id: ss.nextID, title: title, hidden: map[int64]bool{}}
+	ss.nextID++
+	for _, row := range rows {
+		tab.cells = append(tab.cells, append([]interface{}{}, row...))
+	}
+	ss.tabs = append(ss.tabs, tab)
+}
+
+// Titles of a spreadsheet's tabs in index order.
+func (f *fakeSheets) titles(spreadSheetID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var titles []string
+	for _, tab := range f.spreadsheets[spreadSheetID].tabs {
+		titles = append(titles, tab.title)
+	}
+	return titles
+}
+
+func (f *fakeSheets) tab(spreadSheetID string, title string) *fakeTab {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ss := f.spreadsheets[spreadSheetID]; ss != nil {
+		if tab := ss.byTitle(title); tab != nil {
+			return tab
+		}
+	}
+	f.t.Fatalf("no tab %q in %s", title, spreadSheetID)
+	return nil
+}
+
+// The formatted value of a cell such as "F2", or "" when it is empty.
+func (tab *fakeTab) cell(a1 string) string {
+	r, err := parseA1(tab.title + "!" + a1)
+	if err != nil {
+		panic(err)
+	}
+	return formatCell(tab.get(r.row1, r.col1))
+}
+
+// The formatted values of one column between two 1-based rows.
+func (tab *fakeTab) column(column string, firstRow int, lastRow int) []string {
+	var values []string
+	for row := firstRow; row <= lastRow; row++ {
+		values = append(values, tab.cell(fmt.Sprintf("%s%d", column, row)))
+	}
+	return values
+}
+
+func (tab *fakeTab) get(row, col int64) interface{} {
+	if row < int64(len(tab.cells)) && col < int64(len(tab.cells[row])) {
+		return tab.cells[row][col]
+	}
+	return nil
+}
+
+func (tab *fakeTab) set(row, col int64, value interface{}) {
+	for int64(len(tab.cells)) <= row {
+		tab.cells = append(tab.cells, nil)
+	}
+	for int64(len(tab.cells[row])) <= col {
+		tab.cells[row] = append(tab.cells[row], nil)
+	}
+	tab.cells[row][col] = value
+}
+
+func (ss *fakeSpreadsheet) byTitle(title string) *fakeTab {
+	for _, tab := range ss.tabs {
+		if tab.title == title {
+			return tab
+		}
+	}
+	return nil
+}
+
+func (ss *fakeSpreadsheet) byID(id int64) (*fakeTab, int) {
+	for i, tab := range ss.tabs {
+		if tab.id == id {
+			return tab, i
+		}
+	}
+	return nil, -1
+}
+
+// A parsed A1 range, 0-based and inclusive. An open end is -1.
+type a1Range struct {
+	title      string
+	row1, col1 int64
+	row2, col2 int64
+}
+
+// Parse "Tab!B3:C5", "Tab!F2" or "Tab!2:2".
+func parseA1(s string) (a1Range, error) {
+	bang := strings.LastIndex(s, "!")
+	if bang == -1 {
+		return a1Range{}, fmt.Errorf("range %q has no sheet title", s)
+	}
+	r := a1Range{title: strings.Trim(s[:bang], "'")}
+	parts := strings.SplitN(s[bang+1:], ":", 2)
+	var err error
+	if r.row1, r.col1, err = parseCellRef(parts[0]); err != nil {
+		return r, err
+	}
+	r.row2, r.col2 = r.row1, r.col1
+	if len(parts) == 2 {
+		if r.row2, r.col2, err = parseCellRef(parts[1]); err != nil {
+			return r, err
+		}
+	}
+	if r.row1 == -1 {
+		r.row1 = 0
+	}
+	if r.col1 == -1 {
+		r.col1 = 0
+	}
+	return r, nil
+}
+
+// Parse "B3", "B" or "3" into 0-based row and column, -1 where absent.
+func parseCellRef(ref string) (row int64, col int64, err error) {
+	i := strings.IndexAny(ref, "0123456789")
+	if i == -1 {
+		i = len(ref)
+	}
+	row, col = -1, -1
+	if letters := ref[:i]; letters != "" {
+		n, err := excelize.ColumnNameToNumber(letters)
+		if err != nil {
+			return 0, 0, err
+		}
+		col = int64(n) - 1
+	}
+	if digits := ref[i:]; digits != "" {
+		n, err := strconv.ParseInt(digits, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		row = n - 1
+	}
+	if row == -1 && col == -1 {
+		return 0, 0, fmt.Errorf("bad cell reference %q", ref)
+	}
+	return row, col, nil
+}
+
+// Sheets hands back formatted strings, so whole numbers lose their ".0".
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rest := strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/")
+	id := rest
+	if i := strings.IndexAny(rest, "/:"); i != -1 {
+		id, rest = rest[:i], rest[i:]
+	} else {
+		rest = ""
+	}
+	ss := f.spreadsheets[id]
+	if ss == nil {
+		f.fail(w, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+
+	var resp interface{}
+	var err error
+	switch {
+	case rest == "" && r.Method == "GET":
+		resp = ss.describe(id)
+	case rest == ":batchUpdate" && r.Method == "POST":
+		var req sheets.BatchUpdateSpreadsheetRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			resp, err = f.batchUpdate(ss, &req)
+		}
+		if err == nil && f.loseResponse != nil {
+			for _, r := range req.Requests {
+				if f.loseResponse(r) {
+					f.fail(w, http.StatusServiceUnavailable, "The service is currently unavailable.")
+					return
+				}
+			}
+		}
+	case rest == "/values:batchGet" && r.Method == "GET":
+		var ranges []*sheets.ValueRange
+		for _, a1 := range r.URL.Query()["ranges"] {
+			var vr *sheets.ValueRange
+			if vr, err = ss.values(a1, r.URL.Query().Get("majorDimension")); err != nil {
+				break
+			}
+			ranges = append(ranges, vr)
+		}
+		resp = &sheets.BatchGetValuesResponse{SpreadsheetId: id, ValueRanges: ranges}
+	case strings.HasPrefix(rest, "/values/") && r.Method == "GET":
+		resp, err = ss.values(strings.TrimPrefix(rest, "/values/"), r.URL.Query().Get("majorDimension"))
+	case rest == "/values:batchUpdate" && r.Method == "POST":
+		var req sheets.BatchUpdateValuesRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			err = ss.writeValues(req.Data)
+		}
+		resp = &sheets.BatchUpdateValuesResponse{SpreadsheetId: id}
+	default:
+		f.fail(w, http.StatusNotFound, fmt.Sprintf("fake Sheets does not handle %s %s", r.Method, r.URL.Path))
+		return
+	}
+	if err != nil {
+		f.fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Reply with an error body the client library turns into a googleapi.Error.
+func (f *fakeSheets) fail(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	})
+}
+
+func (ss *fakeSpreadsheet) describe(id string) *sheets.Spreadsheet {
+	spreadsheet := &sheets.Spreadsheet{SpreadsheetId: id}
+	for i, tab := range ss.tabs {
+		spreadsheet.Sheets = append(spreadsheet.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{SheetId: tab.id, Title: tab.title, Index: int64(i)},
+		})
+	}
+	return spreadsheet
+}
+
+// Read a range the way the API does, dropping trailing empty cells and rows.
+func (ss *fakeSpreadsheet) values(a1 string, majorDimension string) (*sheets.ValueRange, error) {
+	r, err := parseA1(a1)
+	if err != nil {
+		return nil, err
+	}
+	tab := ss.byTitle(r.title)
+	if tab == nil {
+		return nil, fmt.Errorf("Unable to parse range: %s", a1)
+	}
+	lastRow, lastCol := r.row2, r.col2
+	if lastRow == -1 || lastRow >= int64(len(tab.cells)) {
+		lastRow = int64(len(tab.cells)) - 1
+	}
+	if lastCol == -1 {
+		for _, row := range tab.cells {
+			if int64(len(row))-1 > lastCol {
+				lastCol = int64(len(row)) - 1
+			}
+		}
+	}
+
+	var grid [][]interface{}
+	for row := r.row1; row <= lastRow; row++ {
+		var cells []interface{}
+		for col := r.col1; col <= lastCol; col++ {
+			cells = append(cells, formatCell(tab.get(row, col)))
+		}
+		grid = append(grid, cells)
+	}
+	if majorDimension == "COLUMNS" {
+		var columns [][]interface{}
+		for col := int64(0); col <= lastCol-r.col1; col++ {
+			var cells []interface{}
+			for _, row := range grid {
+				cells = append(cells, row[col])
+			}
+			columns = append(columns, cells)
+		}
+		grid = columns
+	} else {
+		majorDimension = "ROWS"
+	}
+
+	for i, line := range grid {
+		for len(line) > 0 && line[len(line)-1] == "" {
+			line = line[:len(line)-1]
+		}
+		grid[i] = line
+	}
+	for len(grid) > 0 && len(grid[len(grid)-1]) == 0 {
+		grid = grid[:len(grid)-1]
+	}
+	return &sheets.ValueRange{Range: a1, MajorDimension: majorDimension, Values: grid}, nil
+}
+
+func (ss *fakeSpreadsheet) writeValues(data []*sheets.ValueRange) error {
+	for _, vr := range data {
+		r, err := parseA1(vr.Range)
+		if err != nil {
+			return err
+		}
+		tab := ss.byTitle(r.title)
+		if tab == nil {
+			return fmt.Errorf("Unable to parse range: %s", vr.Range)
+		}
+		if vr.MajorDimension != "" && vr.MajorDimension != "ROWS" {
+			return fmt.Errorf("fake Sheets only writes ROWS, got %s", vr.MajorDimension)
+		}
+		for i, row := range vr.Values {
+			for j, value := range row {
+				tab.set(r.row1+int64(i), r.col1+int64(j), value)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply a batch all or nothing, as the API does.
+func (f *fakeSheets) batchUpdate(ss *fakeSpreadsheet, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	if f.failRequest != nil {
+		for _, r := range req.Requests {
+			if f.failRequest(r) {
+				return nil, fmt.Errorf("request rejected by the test")
+			}
+		}
+	}
+	saved := ss.clone()
+	resp := &sheets.BatchUpdateSpreadsheetResponse{}
+	for i, r := range req.Requests {
+		reply, err := ss.apply(r)
+		if err != nil {
+			*ss = *saved
+			return nil, fmt.Errorf("Invalid requests[%d]: %v", i, err)
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	return resp, nil
+}
+
+func (ss *fakeSpreadsheet) clone() *fakeSpreadsheet {
+	c := &fakeSpreadsheet{nextID: ss.nextID}
+	for _, tab := range ss.tabs {
+		c.tabs = append(c.tabs, tab.clone(tab.id, tab.title))
+	}
+	return c
+}
+
+func (tab *fakeTab) clone(id int64, title string) *fakeTab {
+	c := &fakeTab{id: id, title: title, hidden: map[int64]bool{}}
+	for _, row := range tab.cells {
+		c.cells = append(c.cells, append([]interface{}{}, row...))
+	}
+	for col, hidden := range tab.hidden {
+		c.hidden[col] = hidden
+	}
+	return c
+}
+
+func (ss *fakeSpreadsheet) apply(r *sheets.Request) (*sheets.Response, error) {
+	reply := &sheets.Response{}
+	switch {
+	case r.DuplicateSheet != nil:
+		d := r.DuplicateSheet
+		source, _ := ss.byID(d.SourceSheetId)
+		if source == nil {
+			return nil, fmt.Errorf("no sheet with id %d", d.SourceSheetId)
+		}
+		if ss.byTitle(d.NewSheetName) != nil {
+			return nil, fmt.Errorf("a sheet with the name %q already exists", d.NewSheetName)
+		}
+		tab := source.clone(ss.nextID, d.NewSheetName)
+		ss.nextID++
+		index := d.InsertSheetIndex
+		if index > int64(len(ss.tabs)) {
+			index = int64(len(ss.tabs))
+		}
+		ss.tabs = append(ss.tabs[:index], append([]*fakeTab{tab}, ss.tabs[index:]...)...)
+		reply.DuplicateSheet = &sheets.DuplicateSheetResponse{
+			Properties: &sheets.SheetProperties{SheetId: tab.id, Title: tab.title, Index: index},
+		}
+	case r.InsertDimension != nil:
+		d := r.InsertDimension.Range
+		tab, _ := ss.byID(d.SheetId)
+		if tab == nil || d.Dimension != "COLUMNS" {
+			return nil, fmt.Errorf("fake Sheets only inserts columns into known sheets")
+		}
+		count := d.EndIndex - d.StartIndex
+		for i, row := range tab.cells {
+			if int64(len(row)) > d.StartIndex {
+				inserted := append(make([]interface{}, count), row[d.StartIndex:]...)
+				tab.cells[i] = append(row[:d.StartIndex:d.StartIndex], inserted...)
+			}
+		}
+		hidden := map[int64]bool{}
+		for col, h := range tab.hidden {
+			if col >= d.StartIndex {
+				col += count
+			}
+			hidden[col] = h
+		}
+		tab.hidden = hidden
+	case r.UpdateDimensionProperties != nil:
+		d := r.UpdateDimensionProperties.Range
+		tab, _ := ss.byID(d.SheetId)
+		if tab == nil || d.Dimension != "COLUMNS" {
+			return nil, fmt.Errorf("fake Sheets only hides columns of known sheets")
+		}
+		for col := d.StartIndex; col < d.EndIndex; col++ {
+			tab.hidden[col] = r.UpdateDimensionProperties.Properties.HiddenByUser
+		}
+	case r.CopyPaste != nil:
+		c := r.CopyPaste
+		source, _ := ss.byID(c.Source.SheetId)
+		dest, _ := ss.byID(c.Destination.SheetId)
+		if source == nil || dest == nil || c.PasteType != "PASTE_VALUES" {
+			return nil, fmt.Errorf("fake Sheets only pastes values between known sheets")
+		}
+		var block [][]interface{}
+		for row := c.Source.StartRowIndex; row < c.Source.EndRowIndex; row++ {
+			var cells []interface{}
+			for col := c.Source.StartColumnIndex; col < c.Source.EndColumnIndex; col++ {
+				cells = append(cells, source.get(row, col))
+			}
+			block = append(block, cells)
+		}
+		for i, cells := range block {
+			for j, value := range cells {
+				dest.set(c.Destination.StartRowIndex+int64(i), c.Destination.StartColumnIndex+int64(j), value)
+			}
+		}
+	case r.SortRange != nil:
+		g := r.SortRange.Range
+		tab, _ := ss.byID(g.SheetId)
+		if tab == nil {
+			return nil, fmt.Errorf("no sheet with id %d", g.SheetId)
+		}
+		var rows [][]interface{}
+		for row := g.StartRowIndex; row < g.EndRowIndex; row++ {
+			var cells []interface{}
+			for col := g.StartColumnIndex; col < g.EndColumnIndex; col++ {
+				cells = append(cells, tab.get(row, col))
+			}
+			rows = append(rows, cells)
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			for _, spec := range r.SortRange.SortSpecs {
+				col := spec.DimensionIndex - g.StartColumnIndex
+				if c := compareCells(rows[i][col], rows[j][col], spec.SortOrder == "DESCENDING"); c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+		for i, cells := range rows {
+			for j, value := range cells {
+				tab.set(g.StartRowIndex+int64(i), g.StartColumnIndex+int64(j), value)
+			}
+		}
+	case r.UpdateSheetProperties != nil:
+		p := r.UpdateSheetProperties.Properties
+		tab, _ := ss.byID(p.SheetId)
+		if tab == nil || r.UpdateSheetProperties.Fields != "title" {
+			return nil, fmt.Errorf("fake Sheets only renames known sheets")
+		}
+		tab.title = p.Title
+	case r.DeleteSheet != nil:
+		_, i := ss.byID(r.DeleteSheet.SheetId)
+		if i == -1 {
+			return nil, fmt.Errorf("no sheet with id %d", r.DeleteSheet.SheetId)
+		}
+		ss.tabs = append(ss.tabs[:i], ss.tabs[i+1:]...)
+	default:
+		return nil, fmt.Errorf("fake Sheets does not handle %+v", r)
+	}
+	return reply, nil
+}
+
+// Order two cells like Sheets: ascending puts numbers before text, and
+// descending reverses that, but empty cells always go last.
+func compareCells(a, b interface{}, descending bool) int {
+	rank := func(v interface{}) (int, float64, string) {
+		switch v := v.(type) {
+		case nil:
+			return 2, 0, ""
+		case float64:
+			return 0, v, ""
+		case string:
+			if v == "" {
+				return 2, 0, ""
+			}
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return 0, n, ""
+			}
+			return 1, 0, v
+		default:
+			return 1, 0, fmt.Sprint(v)
+		}
+	}
+	rankA, numA, textA := rank(a)
+	rankB, numB, textB := rank(b)
+	if rankA == 2 || rankB == 2 {
+		return rankA/2 - rankB/2
+	}
+	c := 0
+	switch {
+	case rankA != rankB:
+		c = rankA - rankB
+	case numA != numB:
+		if numA < numB {
+			c = -1
+		} else {
+			c = 1
+		}
+	default:
+		c = strings.Compare(textA, textB)
+	}
+	if descending {
+		c = -c
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+// fakeWebDriver serves fixture HTML in place of a Selenium session. Only the
+// calls the scrapers make are implemented; anything else panics through the
+// nil embedded WebDriver, which points straight at the missing method.
+type fakeWebDriver struct {
+	selenium.WebDriver
+
+	// Page source by URL
+	pages   map[string]string
+	current string
+	visited []string
+}
+
+func newFakeWebDriver() *fakeWebDriver {
+	return &fakeWebDriver{pages: map[string]string{}}
+}
+
+// Serve testdata/<fixture> whenever url is loaded.
+func (d *fakeWebDriver) serveFixture(t *testing.T, url string, fixture string) {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.pages[url] = string(b)
+}
+
+func (d *fakeWebDriver) Get(url string) error {
+	d.visited = append(d.visited, url)
+	if _, ok := d.pages[url]; !ok {
+		return &selenium.Error{Err: "unknown error", Message: "net::ERR_NAME_NOT_RESOLVED loading " + url}
+	}
+	d.current = url
+	return nil
+}
+
+func (d *fakeWebDriver) PageSource() (string, error) {
+	return d.pages[d.current], nil
+}
+
+func (d *fakeWebDriver) Screenshot() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *fakeWebDriver) SetImplicitWaitTimeout(timeout time.Duration) error {
+	return nil
+}
+
+func (d *fakeWebDriver) FindElement(by, value string) (selenium.WebElement, error) {
+	return nil, &selenium.Error{Err: "no such element", Message: "unable to locate " + value}
+}
+
+func (d *fakeWebDriver) Quit() error {
+	return nil
+}
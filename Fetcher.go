@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tebeka/selenium"
+	"github.com/tebeka/selenium/chrome"
+	"golang.org/x/net/context"
+)
+
+// pageFetcher loads an account's profile page and returns its HTML.
+type pageFetcher interface {
+	Fetch(ctx context.Context, account *Account) (string, error)
+}
+
+// seleniumFetcher renders pages in a remote browser so JS-built pages work,
+// and keeps a screenshot of each one.
+type seleniumFetcher struct {
+	driver         selenium.WebDriver
+	screenshotPath string
+
+	// Chrome is restarted through newDriver whenever the proxy rotation
+	// moves the session to another proxy or an account's platform calls for
+	// another fingerprint. Without a pool or set the session keeps its own.
+	proxies      *proxyPool
+	proxy        *proxy
+	fingerprints *fingerprintSet
+	session      *Fingerprint
+	fingerprint  *Fingerprint
+	fetches      int
+	newDriver    func(px *proxy, fp *Fingerprint) (selenium.WebDriver, error)
+}
+
+func (f *seleniumFetcher) Fetch(ctx context.Context, account *Account) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", newScrapeError(ScrapeTimeout, account, err)
+	}
+	if err := f.prepare(account); err != nil {
+		return "", newScrapeError(ScrapeFailed, account, err)
+	}
+	account.proxy = f.proxy
+	f.fetches++
+	if err := f.driver.Get(account.FullURL); err != nil {
+		return "", driverError(ScrapeFailed, account, err)
+	}
+
+	screenshot := filepath.Join(f.screenshotPath, account.AccountName+".png")
+	if err := saveScreenshot(f.driver, screenshot); err != nil {
+		log.Printf("Unable to save screenshot for %s: %v", account.AccountName, err)
+	} else {
+		account.ScreenshotPath = screenshot
+	}
+
+	source, err := f.driver.PageSource()
+	if err != nil {
+		return "", driverError(ScrapeFailed, account, err)
+	}
+	return source, nil
+}
+
+// Get the session ready for the account: on another proxy if the last one
+// was retired or every account gets its own, and with the fingerprint the
+// account's platform wants. Chrome only takes either at startup, so a change
+// starts a new browser and quits the old one.
+func (f *seleniumFetcher) prepare(account *Account) error {
+	px := f.proxy
+	if f.proxies != nil && (px == nil || !px.healthy() || (f.fetches > 0 && f.proxies.perAccount)) {
+		next, err := f.proxies.take()
+		if err != nil {
+			return err
+		}
+		px = next
+	}
+	fp := f.fingerprint
+	if f.fingerprints != nil {
+		fp = f.fingerprints.forAccount(f.session, account)
+	}
+	if px == f.proxy && fp == f.fingerprint {
+		return nil
+	}
+
+	driver, err := f.newDriver(px, fp)
+	if err != nil {
+		return fmt.Errorf("starting a new session: %v", err)
+	}
+	if f.driver != nil {
+		f.driver.Quit()
+	}
+	f.driver, f.proxy, f.fingerprint = driver, px, fp
+	return nil
+}
+
+// End the browser session.
+func (f *seleniumFetcher) Close() error {
+	return f.driver.Quit()
+}
+
+// Start a browser session on the grid with the next fingerprint in the
+// rotation, through the next proxy from proxies if there are any.
+func startSeleniumFetcher(cfg *Config, screenshotPath string, proxies *proxyPool, fingerprints *fingerprintSet) (*seleniumFetcher, error) {
+	f := &seleniumFetcher{
+		screenshotPath: screenshotPath,
+		proxies:        proxies,
+		fingerprints:   fingerprints,
+		session:        fingerprints.forSession(),
+		newDriver: func(px *proxy, fp *Fingerprint) (selenium.WebDriver, error) {
+			driver, err := newSeleniumDriver(cfg.SeleniumURL, px, fp)
+			if err != nil {
+				return nil, err
+			}
+			driver.SetPageLoadTimeout(cfg.AccountTimeout.Duration)
+			return driver, nil
+		},
+	}
+	f.fingerprint = f.session
+	if proxies != nil {
+		px, err := proxies.take()
+		if err != nil {
+			return nil, err
+		}
+		f.proxy = px
+	}
+	driver, err := f.newDriver(f.proxy, f.fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	f.driver = driver
+	return f, nil
+}
+
+// Connect to the Selenium grid with a browser presenting fp, sending
+// traffic through px unless it is nil.
+func newSeleniumDriver(seleniumURL string, px *proxy, fp *Fingerprint) (selenium.WebDriver, error) {
+	caps := selenium.Capabilities{
+		"browserName": "chrome",
+	}
+	width, height, err := fp.window()
+	if err != nil {
+		return nil, err
+	}
+	headless := "--headless"
+	var extensions []string
+	if px != nil && px.url.User != nil {
+		extension, err := proxyAuthExtension(px)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, extension)
+		// Extensions only load in Chrome's new headless mode.
+		headless = "--headless=new"
+	}
+	chromeOptions := chrome.Capabilities{
+		Args: []string{
+			"--user-agent=" + fp.UserAgent,
+			"--lang=" + fp.Language,
+			headless,
+			fmt.Sprintf("--window-size=%d,%d", width, height),
+		},
+		ExcludeSwitches: []string{
+			"enable-automation",
+		},
+		Extensions: extensions,
+		Prefs: map[string]interface{}{
+			"intl.accept_languages": fp.acceptLanguage(),
+		},
+	}
+	if px != nil {
+		chromeOptions.Args = append(chromeOptions.Args, "--proxy-server="+px.String())
+	}
+	caps.AddChrome(chromeOptions)
+	driver, err := selenium.NewRemote(caps, seleniumURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := emulateFingerprint(seleniumURL, driver, fp); err != nil {
+		driver.Quit()
+		return nil, fmt.Errorf("applying fingerprint %s: %v", fp.name, err)
+	}
+	return driver, nil
+}
+
+// Save the current page as a PNG at fullPath.
+func saveScreenshot(driver selenium.WebDriver, fullPath string) error {
+	pngBytes, err := driver.Screenshot()
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(pngBytes))
+	if err != nil {
+		return err
+	}
+	outFile, err := os.Create(fullPath)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	return png.Encode(outFile, img)
+}
+
+// httpFetcher downloads the raw profile HTML without a browser. It only works
+// for pages that carry their stats in the initial HTML.
+type httpFetcher struct {
+	client *http.Client
+
+	// Each worker has its own fetcher, which keeps its proxy until the
+	// rotation moves it on and sends its session's fingerprint unless the
+	// account's platform has its own.
+	proxies      *proxyPool
+	proxy        *proxy
+	fingerprints *fingerprintSet
+	session      *Fingerprint
+}
+
+// Context key for the proxy a request should go through.
+type proxyContextKey struct{}
+
+func newHTTPFetcher(proxies *proxyPool, fingerprints *fingerprintSet) *httpFetcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if px, ok := req.Context().Value(proxyContextKey{}).(*proxy); ok {
+			return px.url, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	return &httpFetcher{
+		client:       &http.Client{Timeout: time.Second * 30, Transport: transport},
+		proxies:      proxies,
+		fingerprints: fingerprints,
+		session:      fingerprints.forSession(),
+	}
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, account *Account) (string, error) {
+	if f.proxies != nil {
+		if f.proxy == nil || f.proxies.perAccount || !f.proxy.healthy() {
+			px, err := f.proxies.take()
+			if err != nil {
+				return "", newScrapeError(ScrapeFailed, account, err)
+			}
+			f.proxy = px
+		}
+		account.proxy = f.proxy
+		ctx = context.WithValue(ctx, proxyContextKey{}, f.proxy)
+	}
+	req, err := http.NewRequest("GET", account.FullURL, nil)
+	if err != nil {
+		return "", newScrapeError(ScrapeFailed, account, err)
+	}
+	req = req.WithContext(ctx)
+	fp := f.fingerprints.forAccount(f.session, account)
+	req.Header.Set("User-Agent", fp.UserAgent)
+	req.Header.Set("Accept-Language", fp.acceptLanguage())
+	if fp.Platform != "" {
+		req.Header.Set("Sec-CH-UA-Platform", `"`+fp.Platform+`"`)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", newScrapeError(ScrapeTimeout, account, err)
+		}
+		if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
+			return "", newScrapeError(ScrapeTimeout, account, err)
+		}
+		return "", newScrapeError(ScrapeFailed, account, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", newScrapeError(ScrapeNotFound, account, nil)
+	case resp.StatusCode == http.StatusForbidden:
+		return "", newScrapeError(ScrapeBlocked, account, fmt.Errorf("HTTP %s", resp.Status))
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return "", newScrapeError(ScrapeRateLimited, account, fmt.Errorf("HTTP %s", resp.Status))
+	case resp.StatusCode == http.StatusUnavailableForLegalReasons:
+		return "", newScrapeError(ScrapeRegionBlocked, account, fmt.Errorf("HTTP %s", resp.Status))
+	case resp.StatusCode != http.StatusOK:
+		return "", newScrapeError(ScrapeFailed, account, fmt.Errorf("HTTP %s", resp.Status))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", newScrapeError(ScrapeFailed, account, err)
+	}
+	return string(body), nil
+}
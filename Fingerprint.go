@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tebeka/selenium"
+)
+
+// Fingerprint is how a browser presents itself to the sites we scrape.
+// Profiles are named in the config so one a platform has started blocking
+// can be swapped out without a rebuild.
+type Fingerprint struct {
+	UserAgent string `json:"userAgent"`
+	// Browser window as WIDTHxHEIGHT
+	WindowSize string `json:"windowSize"`
+	// Language tag such as en-US
+	Language string `json:"language"`
+	// IANA time zone such as America/New_York; empty keeps the browser's
+	Timezone string `json:"timezone"`
+	// navigator.platform such as Win32; empty keeps the browser's
+	Platform string `json:"platform"`
+
+	// Key the profile was configured under
+	name string
+}
+
+// The identity we have always scraped with, used when the config names no
+// other.
+const defaultFingerprintName = "applebot"
+
+var defaultFingerprint = Fingerprint{
+	UserAgent:  "Applebot",
+	WindowSize: "1920x1080",
+	Language:   "en-US",
+}
+
+// Width and height of the browser window.
+func (fp *Fingerprint) window() (int, int, error) {
+	var width, height int
+	if _, err := fmt.Sscanf(fp.WindowSize, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("windowSize %q must be WIDTHxHEIGHT", fp.WindowSize)
+	}
+	return width, height, nil
+}
+
+// The Accept-Language header for the profile's language, e.g.
+// "en-US,en;q=0.9".
+func (fp *Fingerprint) acceptLanguage() string {
+	if i := strings.IndexAny(fp.Language, "-_"); i > 0 {
+		return fp.Language + "," + fp.Language[:i] + ";q=0.9"
+	}
+	return fp.Language
+}
+
+// Report what is wrong with the profile, if anything.
+func (fp *Fingerprint) validate() error {
+	if fp.UserAgent == "" {
+		return fmt.Errorf("userAgent is required")
+	}
+	if _, _, err := fp.window(); err != nil {
+		return err
+	}
+	if fp.Language == "" {
+		return fmt.Errorf("language is required")
+	}
+	if fp.Timezone != "" {
+		if _, err := time.LoadLocation(fp.Timezone); err != nil {
+			return fmt.Errorf("timezone: %v", err)
+		}
+	}
+	return nil
+}
+
+// fingerprintSet picks the fingerprint for each session and account: the
+// platform's own profile if it has one, otherwise the session's, which is
+// handed out from the rotation in turn. It is shared by every worker.
+type fingerprintSet struct {
+	rotation   []*Fingerprint
+	byPlatform map[string]*Fingerprint
+
+	mu   sync.Mutex
+	next int
+}
+
+// The profiles the config selects. validate has already checked the names.
+func newFingerprintSet(cfg *Config) *fingerprintSet {
+	profiles := map[string]*Fingerprint{}
+	for name, fp := range cfg.Fingerprints {
+		fp := fp
+		fp.name = name
+		profiles[name] = &fp
+	}
+	s := &fingerprintSet{byPlatform: map[string]*Fingerprint{}}
+	for _, name := range cfg.FingerprintRotation {
+		s.rotation = append(s.rotation, profiles[name])
+	}
+	for platform, name := range cfg.PlatformFingerprints {
+		s.byPlatform[platform] = profiles[name]
+	}
+	return s
+}
+
+// The profile for a new session.
+func (s *fingerprintSet) forSession() *Fingerprint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fp := s.rotation[s.next]
+	s.next = (s.next + 1) % len(s.rotation)
+	return fp
+}
+
+// The profile to fetch the account with in a session using session.
+func (s *fingerprintSet) forAccount(session *Fingerprint, account *Account) *Fingerprint {
+	platform, _ := platformForURL(account.FullURL)
+	if fp, ok := s.byPlatform[platform]; ok {
+		return fp
+	}
+	return session
+}
+
+// Apply the parts of the profile Chrome has no command line switch for,
+// through chromedriver's DevTools passthrough.
+func emulateFingerprint(seleniumURL string, driver selenium.WebDriver, fp *Fingerprint) error {
+	if fp.Timezone != "" {
+		if err := devToolsCommand(seleniumURL, driver, "Emulation.setTimezoneOverride", map[string]interface{}{
+			"timezoneId": fp.Timezone,
+		}); err != nil {
+			return err
+		}
+	}
+	if fp.Platform != "" {
+		if err := devToolsCommand(seleniumURL, driver, "Network.setUserAgentOverride", map[string]interface{}{
+			"userAgent":      fp.UserAgent,
+			"acceptLanguage": fp.acceptLanguage(),
+			"platform":       fp.Platform,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run a Chrome DevTools Protocol command in the driver's browser.
+func devToolsCommand(seleniumURL string, driver selenium.WebDriver, cmd string, params map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"cmd": cmd, "params": params})
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(seleniumURL, "/") + "/session/" + driver.SessionID() + "/goog/cdp/execute"
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %v", cmd, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", cmd, resp.Status)
+	}
+	return nil
+}
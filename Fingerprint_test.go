@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tebeka/selenium"
+	"golang.org/x/net/context"
+)
+
+// Desktop and mobile profiles handed out in turn, with TikTok pinned to a
+// third.
+func testFingerprintConfig() *Config {
+	cfg := defaultConfig()
+	cfg.Fingerprints["desktop"] = Fingerprint{UserAgent: "Desktop", WindowSize: "1920x1080", Language: "en-US", Platform: "Win32"}
+	cfg.Fingerprints["mobile"] = Fingerprint{UserAgent: "Mobile", WindowSize: "390x844", Language: "de-DE", Timezone: "Europe/Berlin"}
+	cfg.Fingerprints["pinned"] = Fingerprint{UserAgent: "Pinned", WindowSize: "1280x800", Language: "fr"}
+	cfg.FingerprintRotation = []string{"desktop", "mobile"}
+	cfg.PlatformFingerprints = map[string]string{"tiktok": "pinned"}
+	return cfg
+}
+
+func TestFingerprintSetRotatesAndPinsPlatforms(t *testing.T) {
+	set := newFingerprintSet(testFingerprintConfig())
+	var sessions []string
+	for i := 0; i < 3; i++ {
+		sessions = append(sessions, set.forSession().name)
+	}
+	expectCells(t, "sessions", sessions, "desktop", "mobile", "desktop")
+
+	session := set.forSession()
+	if fp := set.forAccount(session, tikTokAccount("alpha")); fp.name != "pinned" {
+		t.Errorf("tiktok account got %s, want pinned", fp.name)
+	}
+	other := &Account{AccountName: "alpha", FullURL: "https://www.instagram.com/alpha"}
+	if fp := set.forAccount(session, other); fp != session {
+		t.Errorf("instagram account got %s, want the session's %s", fp.name, session.name)
+	}
+}
+
+func TestSeleniumFetcherRestartsForPlatformFingerprint(t *testing.T) {
+	set := newFingerprintSet(testFingerprintConfig())
+	var started []string
+	newDriver := func(px *proxy, fp *Fingerprint) (selenium.WebDriver, error) {
+		started = append(started, fp.name)
+		driver := newFakeWebDriver()
+		for _, name := range []string{"alpha", "beta"} {
+			driver.serveFixture(t, "https://www.tiktok.com/@"+name, "tiktok/"+name+".html")
+		}
+		return driver, nil
+	}
+	session := set.forSession()
+	driver, _ := newDriver(nil, session)
+	fetcher := &seleniumFetcher{driver: driver, screenshotPath: t.TempDir(), fingerprints: set, session: session, fingerprint: session, newDriver: newDriver}
+
+	for _, name := range []string{"alpha", "beta"} {
+		if _, err := fetcher.Fetch(context.Background(), tikTokAccount(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCells(t, "sessions started", started, "desktop", "pinned")
+}
+
+func TestHTTPFetcherSendsFingerprint(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer server.Close()
+
+	cfg := testFingerprintConfig()
+	cfg.PlatformFingerprints = map[string]string{}
+	fetcher := newHTTPFetcher(nil, newFingerprintSet(cfg))
+	if _, err := fetcher.Fetch(context.Background(), &Account{AccountName: "alpha", FullURL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	expectCells(t, "headers",
+		[]string{headers.Get("User-Agent"), headers.Get("Accept-Language"), headers.Get("Sec-CH-UA-Platform")},
+		"Desktop", "en-US,en;q=0.9", `"Win32"`)
+}
+
+func TestFingerprintConfigIsValidated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"fingerprints": {"windows": {"userAgent": "Windows", "windowSize": "1920x1080", "language": "en-US"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"-config", path,
+		"-fingerprint-rotation", "windows, missing",
+		"-platform-fingerprints", "tiktok=applebot,myspace=windows",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Fingerprints[defaultFingerprintName]; !ok {
+		t.Error("profiles from the file replaced the default one instead of adding to it")
+	}
+	expectCells(t, "rotation", cfg.FingerprintRotation, "windows", "missing")
+	cfg.Fingerprints["broken"] = Fingerprint{UserAgent: "Broken", WindowSize: "wide", Language: "en-US"}
+
+	err = cfg.validate()
+	if err == nil {
+		t.Fatal("config with unknown fingerprints passed validation")
+	}
+	for _, want := range []string{
+		`fingerprints.broken: windowSize "wide" must be WIDTHxHEIGHT`,
+		`fingerprintRotation: no fingerprint named "missing"`,
+		`platformFingerprints: no scraper for platform "myspace"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
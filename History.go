@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Top level bucket holding a bucket per platform, each holding a bucket of
+// captures per account. The same handle on two platforms is two accounts.
+var capturesBucket = []byte("captures")
+
+// Capture is one scrape of one account as kept in the history store.
+type Capture struct {
+	Account    string           `json:"account"`
+	Platform   string           `json:"platform"`
+	Label      string           `json:"label"`
+	CapturedAt time.Time        `json:"capturedAt"`
+	Followers  int64            `json:"followers"`
+	Likes      int64            `json:"likes"`
+	Extra      map[string]int64 `json:"extra,omitempty"`
+	Status     string           `json:"status,omitempty"`
+}
+
+// HistoryStore keeps every capture in a local bbolt file, keyed by platform,
+// account and capture time, so the sheet can be rebuilt from it.
+type HistoryStore struct {
+	db *bolt.DB
+}
+
+func openHistoryStore(path string) (*HistoryStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening history %s: %v", path, err)
+	}
+	return &HistoryStore{db: db}, nil
+}
+
+func (h *HistoryStore) Close() error {
+	return h.db.Close()
+}
+
+// Build the capture to store for an account after captureData has run.
+func captureFromAccount(account *Account) Capture {
+	platform, _ := platformForURL(account.FullURL)
+	c := Capture{
+		Account:    account.AccountName,
+		Platform:   platform,
+		Label:      account.Platform,
+		CapturedAt: account.CapturedAt,
+		Followers:  int64(account.Followers),
+		Likes:      int64(account.Likes),
+		Extra: map[string]int64{
+			"videos":    int64(account.Videos),
+			"following": int64(account.Following),
+		},
+	}
+	if account.CaptureErr != nil {
+		c.Status = account.CaptureErr.Kind.String()
+	}
+	return c
+}
+
+// Record saves a capture, replacing any with the same platform, account and
+// time.
+func (h *HistoryStore) Record(c Capture) error {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(capturesBucket)
+		if err != nil {
+			return err
+		}
+		platform, err := root.CreateBucketIfNotExists([]byte(c.Platform))
+		if err != nil {
+			return err
+		}
+		bucket, err := platform.CreateBucketIfNotExists([]byte(c.Account))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(captureKey(c.CapturedAt), value)
+	})
+}
+
+// History returns an account's captures on a platform from the range
+// [from, to), oldest first. A zero from or to leaves that end of the range
+// open.
+func (h *HistoryStore) History(platform string, account string, from time.Time, to time.Time) ([]Capture, error) {
+	var captures []Capture
+	err := h.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(capturesBucket)
+		if root == nil {
+			return nil
+		}
+		platformBucket := root.Bucket([]byte(platform))
+		if platformBucket == nil {
+			return nil
+		}
+		bucket := platformBucket.Bucket([]byte(account))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		k, v := cursor.First()
+		if !from.IsZero() {
+			k, v = cursor.Seek(captureKey(from))
+		}
+		for ; k != nil; k, v = cursor.Next() {
+			if !to.IsZero() && string(k) >= string(captureKey(to)) {
+				break
+			}
+			var c Capture
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("capture %s/%s/%x: %v", platform, account, k, err)
+			}
+			captures = append(captures, c)
+		}
+		return nil
+	})
+	return captures, err
+}
+
+// Accounts lists every account with stored captures as platform/account,
+// sorted.
+func (h *HistoryStore) Accounts() ([]string, error) {
+	var accounts []string
+	err := h.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(capturesBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(platform, _ []byte) error {
+			bucket := root.Bucket(platform)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(account, _ []byte) error {
+				accounts = append(accounts, string(platform)+"/"+string(account))
+				return nil
+			})
+		})
+	})
+	sort.Strings(accounts)
+	return accounts, err
+}
+
+// Platforms lists the platforms account has stored captures on, sorted.
+func (h *HistoryStore) Platforms(account string) ([]string, error) {
+	var platforms []string
+	err := h.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(capturesBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(platform, _ []byte) error {
+			if bucket := root.Bucket(platform); bucket != nil && bucket.Bucket([]byte(account)) != nil {
+				platforms = append(platforms, string(platform))
+			}
+			return nil
+		})
+	})
+	sort.Strings(platforms)
+	return platforms, err
+}
+
+// CountsByPlatform counts the fetches of every account made since from,
+// failed ones included, by platform. Accounts the daily cap turned away were
+// never fetched, so they are left out.
+func (h *HistoryStore) CountsByPlatform(from time.Time) (map[string]int, error) {
+	counts := map[string]int{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(capturesBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(platform, _ []byte) error {
+			platformBucket := root.Bucket(platform)
+			if platformBucket == nil {
+				return nil
+			}
+			return platformBucket.ForEach(func(account, _ []byte) error {
+				bucket := platformBucket.Bucket(account)
+				if bucket == nil {
+					return nil
+				}
+				cursor := bucket.Cursor()
+				for k, v := cursor.Seek(captureKey(from)); k != nil; k, v = cursor.Next() {
+					var c Capture
+					if err := json.Unmarshal(v, &c); err != nil {
+						return fmt.Errorf("capture %s/%s/%x: %v", platform, account, k, err)
+					}
+					if c.Status != ScrapeCapReached.String() {
+						counts[c.Platform]++
+					}
+				}
+				return nil
+			})
+		})
+	})
+	return counts, err
+}
+
+// Big-endian nanoseconds so keys sort in capture order.
+func captureKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// cogsworth history [flags] [--platform name] <account>
+//
+// Without --platform, lists the account's captures on every platform it has
+// any on.
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("cogsworth history", flag.ExitOnError)
+	platformFlag := fs.String("platform", "", "only show captures from this platform, e.g. tiktok")
+	cfg, rest, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	history, err := openHistoryStore(cfg.HistoryFile)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	if len(rest) != 1 {
+		accounts, err := history.Accounts()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("usage: cogsworth history [flags] [--platform name] <account>\naccounts with history: %v", accounts)
+	}
+
+	platforms := []string{*platformFlag}
+	if *platformFlag == "" {
+		if platforms, err = history.Platforms(rest[0]); err != nil {
+			return err
+		}
+	}
+	var captures []Capture
+	for _, platform := range platforms {
+		platformCaptures, err := history.History(platform, rest[0], time.Time{}, time.Time{})
+		if err != nil {
+			return err
+		}
+		captures = append(captures, platformCaptures...)
+	}
+	if len(captures) == 0 {
+		return fmt.Errorf("no history for %q", rest[0])
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "PLATFORM\tCAPTURED\tFOLLOWERS\tLIKES\tVIDEOS\tFOLLOWING\tSTATUS\t")
+	for _, c := range captures {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t\n", c.Platform, c.CapturedAt.Local().Format("2006-01-02 15:04"),
+			c.Followers, c.Likes, c.Extra["videos"], c.Extra["following"], c.Status)
+	}
+	return w.Flush()
+}
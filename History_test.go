@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryKeepsPlatformsApart(t *testing.T) {
+	history, err := openHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	for _, c := range []Capture{
+		{Account: "alpha", Platform: "tiktok", CapturedAt: testRunDate, Followers: 1500},
+		{Account: "alpha", Platform: "instagram", CapturedAt: testRunDate, Followers: 90},
+	} {
+		if err := history.Record(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	captures, err := history.History("tiktok", "alpha", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 || captures[0].Followers != 1500 {
+		t.Errorf("tiktok history = %+v, want the one 1500 capture", captures)
+	}
+	accounts, _ := history.Accounts()
+	expectCells(t, "accounts", accounts, "instagram/alpha", "tiktok/alpha")
+	platforms, _ := history.Platforms("alpha")
+	expectCells(t, "platforms", platforms, "instagram", "tiktok")
+}
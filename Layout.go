@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Formats a dated header cell may come back in, depending on the cell format.
+var headerDateLayouts = []string{"01/02/2006", "1/2/2006", "1/2/06", "2006-01-02"}
+
+// layoutError means the sheet was read fine but does not look like the
+// layout spreadSheetWork expects, so guessing from the save state is unsafe.
+type layoutError struct {
+	sheet  string
+	reason string
+}
+
+func (e *layoutError) Error() string {
+	return fmt.Sprintf("sheet %q does not match the expected layout: %s", e.sheet, e.reason)
+}
+
+// A run of adjacent columns whose header is a date, as 0-based indexes.
+type dateColumns struct {
+	start, end int64
+}
+
+// Work out the block offsets for this run from the dated headers on the
+// previous run's tab instead of trusting the counters in the save state.
+//
+// The follower block is the first run of dated columns; this run's column
+// goes right after its last one. The later blocks each begin with last
+// week's and this week's columns, which shift right by one once the new
+// follower column is inserted, giving the same offsets UpdateState holds.
+func discoverBlocks(ctx context.Context, srv *sheets.Service, spreadSheetID string, sheetTitle string, upperHeaderRow int64, lowerHeaderRow int64) (*UpdateState, error) {
+	upperRange := fmt.Sprintf("%s!%d:%d", sheetTitle, upperHeaderRow, upperHeaderRow)
+	lowerRange := fmt.Sprintf("%s!%d:%d", sheetTitle, lowerHeaderRow, lowerHeaderRow)
+	var resp *sheets.BatchGetValuesResponse
+	err := sheetsRetryPolicy.do(ctx, func() (err error) {
+		resp, err = srv.Spreadsheets.Values.BatchGet(spreadSheetID).Ranges(upperRange, lowerRange).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.ValueRanges) != 2 {
+		return nil, fmt.Errorf("expected 2 header rows, got %d", len(resp.ValueRanges))
+	}
+
+	upper := findDateColumns(resp.ValueRanges[0].Values)
+	lower := findDateColumns(resp.ValueRanges[1].Values)
+	if len(upper) == 0 {
+		return nil, fmt.Errorf("no dated headers in row %d of %q", upperHeaderRow, sheetTitle)
+	}
+	if len(upper) < 2 {
+		return nil, &layoutError{sheetTitle, fmt.Sprintf("found %d dated block(s) in row %d, expected at least 2", len(upper), upperHeaderRow)}
+	}
+	if len(lower) != len(upper) {
+		return nil, &layoutError{sheetTitle, fmt.Sprintf("row %d has %d dated block(s) but row %d has %d", upperHeaderRow, len(upper), lowerHeaderRow, len(lower))}
+	}
+	for i := range upper {
+		if upper[i] != lower[i] {
+			return nil, &layoutError{sheetTitle, fmt.Sprintf("dated block %d spans columns %d-%d in row %d but %d-%d in row %d",
+				i+1, upper[i].start+1, upper[i].end+1, upperHeaderRow, lower[i].start+1, lower[i].end+1, lowerHeaderRow)}
+		}
+	}
+
+	state := &UpdateState{
+		FirstBlockStart:  upper[0].end + 1,
+		SecondBlockStart: upper[1].start + 3,
+	}
+	if len(upper) > 2 {
+		state.ThirdBlockStart = upper[2].start + 3
+	}
+	return state, nil
+}
+
+// Find the delta block on a tab by its first header, as a 0-based column.
+// A tab no run has written changes to yet has none, so found is false and
+// column is where one should go instead: past an empty column to the right
+// of the last column either header row uses, clear of every dated block.
+func locateDeltaBlock(ctx context.Context, srv *sheets.Service, spreadSheetID string, sheetTitle string, upperHeaderRow int64, lowerHeaderRow int64) (column int64, found bool, err error) {
+	upperRange := fmt.Sprintf("%s!%d:%d", sheetTitle, upperHeaderRow, upperHeaderRow)
+	lowerRange := fmt.Sprintf("%s!%d:%d", sheetTitle, lowerHeaderRow, lowerHeaderRow)
+	var resp *sheets.BatchGetValuesResponse
+	err = sheetsRetryPolicy.do(ctx, func() (err error) {
+		resp, err = srv.Spreadsheets.Values.BatchGet(spreadSheetID).Ranges(upperRange, lowerRange).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return 0, false, fmt.Errorf("reading header rows: %v", err)
+	}
+
+	lastUsed := int64(-1)
+	for _, valueRange := range resp.ValueRanges {
+		if len(valueRange.Values) == 0 {
+			continue
+		}
+		for i, cell := range valueRange.Values[0] {
+			value := strings.TrimSpace(fmt.Sprintf("%v", cell))
+			if value == deltaHeaders[0] {
+				return int64(i), true, nil
+			}
+			if value != "" && int64(i) > lastUsed {
+				lastUsed = int64(i)
+			}
+		}
+	}
+	return lastUsed + 2, false, nil
+}
+
+// Requests adding count empty columns to a tab from the 0-based column
+// start. Sheets only inserts columns inside the grid, so columns wanted past
+// its right edge are appended instead.
+func addColumnsRequest(sheetID int64, start int64, count int64, columnCount int64) *sheets.Request {
+	if columnCount > 0 && start >= columnCount {
+		return &sheets.Request{AppendDimension: &sheets.AppendDimensionRequest{
+			SheetId:   sheetID,
+			Dimension: "COLUMNS",
+			Length:    start - columnCount + count,
+		}}
+	}
+	return &sheets.Request{InsertDimension: &sheets.InsertDimensionRequest{
+		Range: &sheets.DimensionRange{
+			SheetId:    sheetID,
+			Dimension:  "COLUMNS",
+			StartIndex: start,
+			EndIndex:   start + count,
+		},
+	}}
+}
+
+// Group the dated cells of a header row into runs of adjacent columns.
+func findDateColumns(rows [][]interface{}) []dateColumns {
+	var groups []dateColumns
+	if len(rows) == 0 {
+		return groups
+	}
+	inGroup := false
+	for i, cell := range rows[0] {
+		if !isHeaderDate(fmt.Sprintf("%v", cell)) {
+			inGroup = false
+			continue
+		}
+		if inGroup {
+			groups[len(groups)-1].end = int64(i)
+		} else {
+			groups = append(groups, dateColumns{start: int64(i), end: int64(i)})
+			inGroup = true
+		}
+	}
+	return groups
+}
+
+func isHeaderDate(value string) bool {
+	_, ok := headerDate(value)
+	return ok
+}
+
+// The date a header cell holds, in any of the layouts the sheet uses.
+func headerDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range headerDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Each run's tab is titled with its date and weekday initial, e.g. "08/11/2020 (T)".
+const tabDateLayout = "01/02/2006"
+
+func sheetTitle(date time.Time) string {
+	return date.Format(tabDateLayout) + " (" + date.Weekday().String()[:1] + ")"
+}
+
+// The date a run's tab was created for, if title is exactly the title
+// sheetTitle gives it. Anything else, such as a tab set aside during a
+// rebuild, is not a dated tab.
+func tabDate(title string) (time.Time, bool) {
+	open := strings.Index(title, " (")
+	if open == -1 {
+		return time.Time{}, false
+	}
+	date, err := time.Parse(tabDateLayout, title[:open])
+	if err != nil || sheetTitle(date) != title {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// Pick the tab this run copies: the one named by fromSheet when set,
+// otherwise the most recently dated tab from before runDate.
+func findPreviousSheet(tabs []*sheets.Sheet, fromSheet string, runDate time.Time) (*sheets.SheetProperties, error) {
+	if fromSheet != "" {
+		for _, sh := range tabs {
+			if sh.Properties.Title == fromSheet {
+				return sh.Properties, nil
+			}
+		}
+		return nil, fmt.Errorf("no tab named %q", fromSheet)
+	}
+
+	runDay, _ := time.Parse(tabDateLayout, runDate.Format(tabDateLayout))
+	var previous *sheets.SheetProperties
+	var previousDate time.Time
+	for _, sh := range tabs {
+		date, ok := tabDate(sh.Properties.Title)
+		if !ok || !date.Before(runDay) {
+			continue
+		}
+		if previous == nil || date.After(previousDate) {
+			previous = sh.Properties
+			previousDate = date
+		}
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("no dated tab before %s; use --from-sheet to pick one", runDate.Format(tabDateLayout))
+	}
+	return previous, nil
+}
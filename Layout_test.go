@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestTabDateNeedsExactTitle(t *testing.T) {
+	tests := map[string]bool{
+		"10/11/2026 (S)":                  true,
+		"10/11/2026 (S) (before rebuild)": false,
+		"10/11/2026 (M)":                  false,
+		"10/11/2026":                      false,
+		"Copy of 10/11/2026 (S)":          false,
+		"TikTok URLs":                     false,
+	}
+	for title, want := range tests {
+		if _, ok := tabDate(title); ok != want {
+			t.Errorf("tabDate(%q) ok = %v, want %v", title, ok, want)
+		}
+	}
+}
+
+func TestFindPreviousSheet(t *testing.T) {
+	tabs := func(titles ...string) []*sheets.Sheet {
+		var tabs []*sheets.Sheet
+		for i, title := range titles {
+			tabs = append(tabs, &sheets.Sheet{Properties: &sheets.SheetProperties{SheetId: int64(i), Title: title}})
+		}
+		return tabs
+	}
+	tests := []struct {
+		name      string
+		tabs      []*sheets.Sheet
+		fromSheet string
+		want      string
+		wantErr   string
+	}{
+		{name: "last week", tabs: tabs("TikTok URLs", "10/04/2026 (S)", "10/11/2026 (S)"), want: "10/11/2026 (S)"},
+		// A week without a run leaves the tab from two weeks ago newest.
+		{name: "skipped week", tabs: tabs("09/27/2026 (S)", "10/04/2026 (S)"), want: "10/04/2026 (S)"},
+		{name: "out of order", tabs: tabs("10/11/2026 (S)", "09/27/2026 (S)"), want: "10/11/2026 (S)"},
+		{name: "mid-week run", tabs: tabs("10/11/2026 (S)", "10/14/2026 (W)"), want: "10/14/2026 (W)"},
+		// Today's own tab and later ones are not copied.
+		{name: "today exists", tabs: tabs("10/11/2026 (S)", "10/18/2026 (S)", "10/25/2026 (S)"), want: "10/11/2026 (S)"},
+		{name: "set aside", tabs: tabs("10/04/2026 (S)", "10/11/2026 (S) (before rebuild)"), want: "10/04/2026 (S)"},
+		{name: "from sheet", tabs: tabs("Template", "10/11/2026 (S)"), fromSheet: "Template", want: "Template"},
+		{name: "from missing sheet", tabs: tabs("10/11/2026 (S)"), fromSheet: "Template", wantErr: `no tab named "Template"`},
+		{name: "no dated tab", tabs: tabs("TikTok URLs", "10/18/2026 (S)"), wantErr: "no dated tab before 10/18/2026; use --from-sheet"},
+	}
+	for _, test := range tests {
+		got, err := findPreviousSheet(test.tabs, test.fromSheet, testRunDate)
+		switch {
+		case test.wantErr != "":
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error %v, want %q", test.name, err, test.wantErr)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case got.Title != test.want:
+			t.Errorf("%s: got %q, want %q", test.name, got.Title, test.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+
+	// Excel Functions
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+// sheetWriter sends spreadSheetWork's changes to the spreadsheet. Reads
+// always go to the Sheets API; only the writes go through here, so a dry run
+// can swap in a writer that records them instead.
+type sheetWriter interface {
+	BatchUpdate(ctx context.Context, requests []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error)
+	WriteValues(ctx context.Context, data []*sheets.ValueRange) error
+}
+
+// liveWriter applies changes to the spreadsheet, retrying as usual.
+type liveWriter struct {
+	srv           *sheets.Service
+	spreadSheetID string
+}
+
+func (w *liveWriter) BatchUpdate(ctx context.Context, requests []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: requests,
+	}
+	// A server error may come after a tab or column was already added, and
+	// sending it again would add a second one, so those batches are only
+	// retried when the server turned them away.
+	policy := sheetsRetryPolicy
+	if !repeatable(requests) {
+		policy.Retryable = rejectedSheetsError
+	}
+	var resp *sheets.BatchUpdateSpreadsheetResponse
+	err := policy.do(ctx, func() (err error) {
+		resp, err = w.srv.Spreadsheets.BatchUpdate(w.spreadSheetID, batchReq).Context(ctx).Do()
+		return err
+	})
+	return resp, err
+}
+
+// Whether applying the requests twice leaves the spreadsheet as applying
+// them once would. Copies, sorts, hiding and renames do; adding or deleting
+// tabs and columns does not.
+func repeatable(requests []*sheets.Request) bool {
+	for _, r := range requests {
+		if r.DuplicateSheet != nil || r.AddSheet != nil || r.DeleteSheet != nil ||
+			r.InsertDimension != nil || r.AppendDimension != nil || r.DeleteDimension != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *liveWriter) WriteValues(ctx context.Context, data []*sheets.ValueRange) error {
+	valuesReq := &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             data,
+	}
+	return sheetsRetryPolicy.do(ctx, func() error {
+		_, err := w.srv.Spreadsheets.Values.BatchUpdate(w.spreadSheetID, valuesReq).Context(ctx).Do()
+		return err
+	})
+}
+
+// The sheet ID a dry run hands out for the tab it pretends to create.
+const plannedSheetID = -1
+
+// planWriter records every change as a line of the plan instead of sending
+// it. Sheet IDs in the requests are shown by their tab titles.
+type planWriter struct {
+	titles map[int64]string
+	steps  []string
+}
+
+func newPlanWriter(tabs []*sheets.Sheet) *planWriter {
+	titles := map[int64]string{}
+	for _, sh := range tabs {
+		titles[sh.Properties.SheetId] = sh.Properties.Title
+	}
+	return &planWriter{titles: titles}
+}
+
+func (w *planWriter) BatchUpdate(ctx context.Context, requests []*sheets.Request) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	resp := &sheets.BatchUpdateSpreadsheetResponse{}
+	for _, r := range requests {
+		reply := &sheets.Response{}
+		switch {
+		case r.DuplicateSheet != nil:
+			d := r.DuplicateSheet
+			w.titles[plannedSheetID] = d.NewSheetName
+			w.add("Duplicate %q as %q at index %d", w.titles[d.SourceSheetId], d.NewSheetName, d.InsertSheetIndex)
+			reply.DuplicateSheet = &sheets.DuplicateSheetResponse{
+				Properties: &sheets.SheetProperties{SheetId: plannedSheetID, Title: d.NewSheetName, Index: d.InsertSheetIndex},
+			}
+		case r.InsertDimension != nil:
+			w.add("Insert %s", w.dimension(r.InsertDimension.Range))
+		case r.AppendDimension != nil:
+			a := r.AppendDimension
+			w.add("Append %d %s to %q", a.Length, strings.ToLower(a.Dimension), w.titles[a.SheetId])
+		case r.UpdateDimensionProperties != nil:
+			action := "Show"
+			if r.UpdateDimensionProperties.Properties.HiddenByUser {
+				action = "Hide"
+			}
+			w.add("%s %s", action, w.dimension(r.UpdateDimensionProperties.Range))
+		case r.CopyPaste != nil:
+			w.add("Copy %s to %s", w.gridRange(r.CopyPaste.Source), w.gridRange(r.CopyPaste.Destination))
+		case r.SortRange != nil:
+			var specs []string
+			for _, spec := range r.SortRange.SortSpecs {
+				column, _ := excelize.ColumnNumberToName(int(spec.DimensionIndex) + 1)
+				specs = append(specs, fmt.Sprintf("column %s %s", column, strings.ToLower(spec.SortOrder)))
+			}
+			w.add("Sort %s by %s", w.gridRange(r.SortRange.Range), strings.Join(specs, ", "))
+		case r.UpdateSheetProperties != nil:
+			p := r.UpdateSheetProperties.Properties
+			w.add("Rename %q to %q", w.titles[p.SheetId], p.Title)
+		case r.DeleteSheet != nil:
+			w.add("Delete %q", w.titles[r.DeleteSheet.SheetId])
+		default:
+			w.add("Send %+v", r)
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	return resp, nil
+}
+
+func (w *planWriter) WriteValues(ctx context.Context, data []*sheets.ValueRange) error {
+	for _, v := range data {
+		var cells []string
+		for _, row := range v.Values {
+			for _, cell := range row {
+				cells = append(cells, fmt.Sprintf("%v", cell))
+			}
+		}
+		w.add("Write %s = %s", v.Range, strings.Join(cells, " | "))
+	}
+	return nil
+}
+
+func (w *planWriter) add(format string, args ...interface{}) {
+	w.steps = append(w.steps, fmt.Sprintf(format, args...))
+}
+
+// A span such as `column D of "tab"` or `rows 3:5 of "tab"`.
+func (w *planWriter) dimension(d *sheets.DimensionRange) string {
+	kind := "column"
+	name := func(i int64) string {
+		column, _ := excelize.ColumnNumberToName(int(i) + 1)
+		return column
+	}
+	if d.Dimension == "ROWS" {
+		kind = "row"
+		name = func(i int64) string { return fmt.Sprint(i + 1) }
+	}
+	span := kind + " " + name(d.StartIndex)
+	if d.EndIndex-d.StartIndex > 1 {
+		span = kind + "s " + name(d.StartIndex) + ":" + name(d.EndIndex-1)
+	}
+	return fmt.Sprintf("%s of %q", span, w.titles[d.SheetId])
+}
+
+// A grid range in A1 notation.
+func (w *planWriter) gridRange(g *sheets.GridRange) string {
+	first, _ := excelize.ColumnNumberToName(int(g.StartColumnIndex) + 1)
+	last, _ := excelize.ColumnNumberToName(int(g.EndColumnIndex))
+	return fmt.Sprintf("%s!%s%d:%s%d", w.titles[g.SheetId], first, g.StartRowIndex+1, last, g.EndRowIndex)
+}
+
+func (w *planWriter) print(out io.Writer) {
+	fmt.Fprintln(out, "Dry run; the spreadsheet would be changed as follows:")
+	for i, step := range w.steps {
+		fmt.Fprintf(out, "%3d. %s\n", i+1, step)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// What one worker made of one account, by its place in the account list.
+type captureResult struct {
+	index int
+	err   error
+}
+
+// Capture every account, one worker per fetcher, giving each account its own
+// timeout once the limiter lets it go. Each fetcher is only used by its own
+// worker, so a Selenium session never sees two pages at once. Failures are
+// logged and set on the accounts in list order once all are done; the number
+// of failures is returned.
+func captureAll(accounts []*Account, fetchers []pageFetcher, timeout time.Duration, limiter *rateLimiter) int {
+	jobs := make(chan int)
+	results := make(chan captureResult)
+	for _, fetcher := range fetchers {
+		scrapers := newScrapers(fetcher)
+		go func() {
+			for i := range jobs {
+				if err := limiter.wait(context.Background(), accounts[i]); err != nil {
+					results <- captureResult{i, err}
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				err := captureData(ctx, accounts[i], scrapers)
+				// Whatever went wrong once time ran out, the cause is the timeout.
+				if se, ok := err.(*ScrapeError); ok && se.Kind != ScrapeTimeout && ctx.Err() == context.DeadlineExceeded {
+					err = newScrapeError(ScrapeTimeout, accounts[i], se.Err)
+				}
+				cancel()
+				if se, ok := err.(*ScrapeError); ok && se.Kind.blocking() {
+					limiter.blocked(accounts[i])
+				}
+				if px := accounts[i].proxy; px != nil {
+					px.record(err)
+				}
+				results <- captureResult{i, err}
+			}
+		}()
+	}
+	go func() {
+		for i := range accounts {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	errs := make([]error, len(accounts))
+	for range accounts {
+		result := <-results
+		errs[result.index] = result.err
+	}
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Unable to capture %v", err)
+			accounts[i].CaptureErr = err.(*ScrapeError)
+			failed++
+		}
+	}
+	return failed
+}
+
+// Number of Chrome sessions the Selenium grid can run at once, read from its
+// status endpoint.
+func gridCapacity(seleniumURL string) (int, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(seleniumURL, "/") + "/status")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("grid status: %s", resp.Status)
+	}
+
+	var status struct {
+		Value struct {
+			Nodes []struct {
+				Availability string `json:"availability"`
+				Slots        []struct {
+					Stereotype struct {
+						BrowserName string `json:"browserName"`
+					} `json:"stereotype"`
+				} `json:"slots"`
+			} `json:"nodes"`
+		} `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("grid status: %v", err)
+	}
+	slots := 0
+	for _, node := range status.Value.Nodes {
+		if node.Availability != "" && node.Availability != "UP" {
+			continue
+		}
+		for _, slot := range node.Slots {
+			if slot.Stereotype.BrowserName == "chrome" {
+				slots++
+			}
+		}
+	}
+	if slots == 0 {
+		return 0, fmt.Errorf("grid status lists no chrome slots")
+	}
+	return slots, nil
+}
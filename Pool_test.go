@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// stubFetcher serves a TikTok page whose follower count is the account's
+// CountNum, after a delay, and remembers which accounts it was given.
+type stubFetcher struct {
+	delay func(account *Account) time.Duration
+
+	mu     sync.Mutex
+	served []string
+}
+
+func (f *stubFetcher) Fetch(ctx context.Context, account *Account) (string, error) {
+	f.mu.Lock()
+	f.served = append(f.served, account.AccountName)
+	f.mu.Unlock()
+	select {
+	case <-time.After(f.delay(account)):
+	case <-ctx.Done():
+		return "", newScrapeError(ScrapeFailed, account, ctx.Err())
+	}
+	return fmt.Sprintf(`<script id="__UNIVERSAL_DATA_FOR_REHYDRATION__">{"__DEFAULT_SCOPE__":{"webapp.user-detail":{"userInfo":{"stats":{"followerCount":%d}}}}}</script>`, account.CountNum), nil
+}
+
+func poolAccounts(n int) []*Account {
+	var accounts []*Account
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf("account%d", i)
+		accounts = append(accounts, &Account{CountNum: i, AccountName: name, FullURL: "https://www.tiktok.com/@" + name})
+	}
+	return accounts
+}
+
+func TestCaptureAllSpreadsAccountsAndKeepsOrder(t *testing.T) {
+	accounts := poolAccounts(8)
+	// Early accounts are slowest, so results come back out of order.
+	delay := func(account *Account) time.Duration { return time.Duration(9-account.CountNum) * 5 * time.Millisecond }
+	first, second := &stubFetcher{delay: delay}, &stubFetcher{delay: delay}
+
+	if failed := captureAll(accounts, []pageFetcher{first, second}, time.Minute, unlimited()); failed != 0 {
+		t.Fatalf("%d accounts failed", failed)
+	}
+	for i, account := range accounts {
+		if account.Followers != i+1 || account.CaptureErr != nil {
+			t.Errorf("account %d has %d followers and error %v", i+1, account.Followers, account.CaptureErr)
+		}
+	}
+	if len(first.served) == 0 || len(second.served) == 0 || len(first.served)+len(second.served) != len(accounts) {
+		t.Errorf("fetchers served %v and %v", first.served, second.served)
+	}
+}
+
+func TestCaptureAllTimesOutSlowAccounts(t *testing.T) {
+	accounts := poolAccounts(3)
+	slow := &stubFetcher{delay: func(account *Account) time.Duration {
+		if account.CountNum == 2 {
+			return time.Minute
+		}
+		return 0
+	}}
+
+	if failed := captureAll(accounts, []pageFetcher{slow}, 50*time.Millisecond, unlimited()); failed != 1 {
+		t.Fatalf("%d accounts failed, want 1", failed)
+	}
+	if err := accounts[1].CaptureErr; err == nil || err.Kind != ScrapeTimeout {
+		t.Errorf("slow account failed with %v, want a timeout", err)
+	}
+	if accounts[2].Followers != 3 {
+		t.Errorf("account after the slow one has %d followers, want 3", accounts[2].Followers)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+)
+
+// proxyPool hands out the configured proxies in turn, skipping any that have
+// been retired for serving too many block pages in a row. It is shared by
+// every worker of a run.
+type proxyPool struct {
+	// Give every account a fresh proxy rather than one per session
+	perAccount bool
+	// Block pages in a row that retire a proxy; 0 never retires one
+	maxBlocks int
+
+	mu      sync.Mutex
+	proxies []*proxy
+	next    int
+}
+
+// proxy is one entry in the pool, with how it has been doing.
+type proxy struct {
+	url  *url.URL
+	pool *proxyPool
+	// Block pages served in a row, guarded by pool.mu
+	blocks  int
+	retired bool
+}
+
+// A pool of the config's proxies, or nil if none are configured.
+func newProxyPool(cfg *Config) (*proxyPool, error) {
+	if len(cfg.Proxies) == 0 {
+		return nil, nil
+	}
+	pool := &proxyPool{perAccount: cfg.ProxyRotation == "account", maxBlocks: cfg.ProxyMaxBlocks}
+	for i, raw := range cfg.Proxies {
+		u, err := parseProxy(raw)
+		if err != nil {
+			return nil, fmt.Errorf("proxies[%d]: %v", i, err)
+		}
+		pool.proxies = append(pool.proxies, &proxy{url: u, pool: pool})
+	}
+	return pool, nil
+}
+
+// Parse an http, https or socks5 proxy URL, with optional user:password.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// Not err itself, which quotes the URL password and all.
+		return nil, fmt.Errorf("not a valid URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%s must be an http, https or socks5 URL", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// The next proxy still in service.
+func (p *proxyPool) take() (*proxy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for range p.proxies {
+		px := p.proxies[p.next]
+		p.next = (p.next + 1) % len(p.proxies)
+		if !px.retired {
+			return px, nil
+		}
+	}
+	return nil, fmt.Errorf("all %d proxies have been retired for serving block pages", len(p.proxies))
+}
+
+// Note how a fetch through the proxy turned out. A block page or captcha
+// counts against it; anything else clears its record.
+func (px *proxy) record(err error) {
+	p := px.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if se, ok := err.(*ScrapeError); !ok || !se.Kind.blocking() {
+		px.blocks = 0
+		return
+	}
+	px.blocks++
+	if p.maxBlocks > 0 && px.blocks >= p.maxBlocks && !px.retired {
+		px.retired = true
+		log.Printf("Retiring proxy %s after %d block pages in a row", px, px.blocks)
+	}
+}
+
+func (px *proxy) healthy() bool {
+	px.pool.mu.Lock()
+	defer px.pool.mu.Unlock()
+	return !px.retired
+}
+
+// The proxy's address without its credentials, for logs and Chrome's
+// --proxy-server.
+func (px *proxy) String() string {
+	return px.url.Scheme + "://" + px.url.Host
+}
+
+// Build a Chrome extension that answers the proxy's login prompt, since
+// Chrome ignores credentials given in --proxy-server. Returned base64
+// encoded as chromedriver expects.
+func proxyAuthExtension(px *proxy) (string, error) {
+	username := px.url.User.Username()
+	password, _ := px.url.User.Password()
+	credentials, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{
+		"manifest.json": `{
+  "manifest_version": 3,
+  "name": "Cogsworth proxy login",
+  "version": "1.0",
+  "permissions": ["webRequest", "webRequestAuthProvider"],
+  "host_permissions": ["<all_urls>"],
+  "background": {"service_worker": "background.js"}
+}`,
+		"background.js": `chrome.webRequest.onAuthRequired.addListener(
+  (details, callback) => callback({authCredentials: ` + string(credentials) + `}),
+  {urls: ["<all_urls>"]},
+  ["asyncBlocking"]
+);`,
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"manifest.json", "background.js"} {
+		f, err := w.Create(name)
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write([]byte(files[name])); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// rateLimiter spaces out profile fetches so a site does not see us hammering
+// it. Fetches to one domain start at most requestsPerMinute a minute, each
+// gap stretched by up to jitter at random; each platform gets at most
+// dailyCap fetches a day; and a domain that served a block page is left
+// alone for coolDown. It is shared by every worker of a run.
+type rateLimiter struct {
+	interval time.Duration
+	jitter   time.Duration
+	dailyCap int
+	coolDown time.Duration
+
+	mu sync.Mutex
+	// Earliest time the next fetch to each domain may start
+	next map[string]time.Time
+	// End of each domain's cool-down, which also holds fetches booked
+	// before it began
+	coolUntil map[string]time.Time
+	// Fetches made today by platform, including earlier runs
+	used map[string]int
+}
+
+// A limiter for the config's settings, counting the fetches already made
+// today from usedToday.
+func newRateLimiter(cfg *Config, usedToday map[string]int) *rateLimiter {
+	l := &rateLimiter{
+		jitter:    cfg.FetchJitter.Duration,
+		dailyCap:  cfg.DailyCap,
+		coolDown:  cfg.BlockCoolDown.Duration,
+		next:      map[string]time.Time{},
+		coolUntil: map[string]time.Time{},
+		used:      map[string]int{},
+	}
+	if cfg.RequestsPerMinute > 0 {
+		l.interval = time.Minute / time.Duration(cfg.RequestsPerMinute)
+	}
+	for platform, n := range usedToday {
+		l.used[platform] = n
+	}
+	return l
+}
+
+// Wait for the account's turn to be fetched. The slot is booked before
+// waiting, so workers sharing the limiter queue up in order; one whose
+// domain went into a cool-down while it waited is booked again after it. An
+// account whose platform has used its daily cap is refused at once.
+func (l *rateLimiter) wait(ctx context.Context, account *Account) error {
+	platform, _ := platformForURL(account.FullURL)
+	domain := fetchDomain(account.FullURL)
+
+	l.mu.Lock()
+	if l.dailyCap > 0 && l.used[platform] >= l.dailyCap {
+		l.mu.Unlock()
+		return newScrapeError(ScrapeCapReached, account, fmt.Errorf("%d %s fetches already made today", l.dailyCap, platform))
+	}
+	l.used[platform]++
+	start := l.book(domain)
+	l.mu.Unlock()
+
+	for {
+		timer := time.NewTimer(time.Until(start))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return newScrapeError(ScrapeTimeout, account, ctx.Err())
+		}
+
+		l.mu.Lock()
+		if !l.coolUntil[domain].After(time.Now()) {
+			l.mu.Unlock()
+			return nil
+		}
+		start = l.book(domain)
+		l.mu.Unlock()
+	}
+}
+
+// Reserve the next slot for a fetch to domain and return when it starts.
+// The caller holds mu.
+func (l *rateLimiter) book(domain string) time.Time {
+	start := time.Now()
+	if l.next[domain].After(start) {
+		start = l.next[domain]
+	}
+	gap := l.interval
+	if l.jitter > 0 {
+		gap += time.Duration(rand.Int63n(int64(l.jitter)))
+	}
+	l.next[domain] = start.Add(gap)
+	return start
+}
+
+// Hold off the account's domain after it served a block page or captcha.
+func (l *rateLimiter) blocked(account *Account) {
+	domain := fetchDomain(account.FullURL)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(l.coolDown)
+	if until.After(l.coolUntil[domain]) {
+		l.coolUntil[domain] = until
+	}
+	if until.After(l.next[domain]) {
+		l.next[domain] = until
+	}
+}
+
+// The host a profile is fetched from, e.g. www.tiktok.com.
+func fetchDomain(fullURL string) string {
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return fullURL
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A limiter that never holds anything up.
+func unlimited() *rateLimiter {
+	return newRateLimiter(&Config{}, nil)
+}
+
+func tikTokAccount(name string) *Account {
+	return &Account{AccountName: name, FullURL: "https://www.tiktok.com/@" + name}
+}
+
+func TestRateLimiterSpacesFetchesPerDomain(t *testing.T) {
+	l := newRateLimiter(&Config{RequestsPerMinute: 600}, nil)
+	start := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := l.wait(context.Background(), tikTokAccount(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("three fetches at 600/minute took %v, want at least 200ms", elapsed)
+	}
+
+	// Another domain has its own schedule.
+	start = time.Now()
+	if err := l.wait(context.Background(), &Account{AccountName: "d", FullURL: "https://www.instagram.com/d"}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("first fetch to a new domain waited %v", elapsed)
+	}
+}
+
+func TestRateLimiterDailyCapCountsEarlierRuns(t *testing.T) {
+	l := newRateLimiter(&Config{DailyCap: 3}, map[string]int{"tiktok": 2})
+	if err := l.wait(context.Background(), tikTokAccount("a")); err != nil {
+		t.Fatal(err)
+	}
+	err := l.wait(context.Background(), tikTokAccount("b"))
+	if se, ok := err.(*ScrapeError); !ok || se.Kind != ScrapeCapReached {
+		t.Errorf("fetch past the cap returned %v, want a daily cap error", err)
+	}
+}
+
+func TestRateLimiterCoolsDownBlockedDomain(t *testing.T) {
+	l := newRateLimiter(&Config{BlockCoolDown: Duration{time.Hour}}, nil)
+	l.blocked(tikTokAccount("a"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := l.wait(ctx, tikTokAccount("b"))
+	if se, ok := err.(*ScrapeError); !ok || se.Kind != ScrapeTimeout {
+		t.Errorf("fetch during the cool-down returned %v, want to be held until the timeout", err)
+	}
+}
+
+// Fetches booked before a domain served a block page wait out the cool-down
+// too, still spaced apart, rather than going ahead as booked.
+func TestRateLimiterCoolDownHoldsBookedFetches(t *testing.T) {
+	l := newRateLimiter(&Config{RequestsPerMinute: 600, BlockCoolDown: Duration{400 * time.Millisecond}}, nil)
+	start := time.Now()
+	if err := l.wait(context.Background(), tikTokAccount("a")); err != nil {
+		t.Fatal(err)
+	}
+	released := make(chan time.Duration, 2)
+	for _, name := range []string{"b", "c"} {
+		go func(name string) {
+			if err := l.wait(context.Background(), tikTokAccount(name)); err != nil {
+				t.Error(err)
+			}
+			released <- time.Since(start)
+		}(name)
+	}
+	time.Sleep(20 * time.Millisecond)
+	l.blocked(tikTokAccount("a"))
+
+	first, second := <-released, <-released
+	if first < 400*time.Millisecond {
+		t.Errorf("booked fetch released after %v, during the 400ms cool-down", first)
+	}
+	if second-first < 90*time.Millisecond {
+		t.Errorf("fetches after the cool-down %v apart, want the 100ms interval", second-first)
+	}
+}
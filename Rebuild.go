@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+)
+
+// cogsworth rebuild --date 2006-01-02 [--csv values.csv]
+//
+// Regenerates the dated tab for one run. The tab before it supplies the
+// earlier weeks and the layout, exactly as a normal run would use it, and the
+// run's own numbers come from the history store or from a CSV with
+// account,followers,likes columns. Accounts the history has nothing for that
+// day, as for runs from before it existed, are read from that date's column
+// in the next dated tab. An existing tab for that date is set aside while the
+// new one is built and deleted once it succeeds.
+//
+// The tab before must be intact, so a run of bad tabs is rebuilt oldest
+// first.
+func runRebuild(args []string) error {
+	fs := flag.NewFlagSet("cogsworth rebuild", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `usage: cogsworth rebuild --date 2006-01-02 [--csv values.csv] [flags]
+
+Replays the run for --date: the tab before it is duplicated, exactly as a
+normal run would, and that run's numbers are filled in from the CSV, or else
+from the history database, reading any account it has nothing for from the
+date's column in the next dated tab. The earlier weeks and the layout come
+from the previous tab as it stands, so it must be intact; if several tabs in
+a row are bad, rebuild them oldest first.
+
+`)
+		fs.PrintDefaults()
+	}
+	dateFlag := fs.String("date", "", "date of the tab to rebuild, as 2006-01-02")
+	csvFlag := fs.String("csv", "", "CSV of account,followers,likes to use instead of the history store")
+	cfg, _, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if cfg.Output != "sheets" {
+		return errors.New("rebuild only updates the Google Sheet; the xlsx output is rebuilt from history every run")
+	}
+	if *dateFlag == "" {
+		return errors.New("rebuild needs --date")
+	}
+	runDate, err := time.ParseInLocation("2006-01-02", *dateFlag, time.Local)
+	if err != nil {
+		return fmt.Errorf("--date: %v", err)
+	}
+
+	srv, err := newSheetsService(cfg)
+	if err != nil {
+		return err
+	}
+	history, err := openHistoryStore(cfg.HistoryFile)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+	return rebuild(cfg, srv, history, runDate, *csvFlag)
+}
+
+// Rebuild runDate's tab once the services are connected, so tests can run it
+// against fakes of them.
+func rebuild(cfg *Config, srv *sheets.Service, history *HistoryStore, runDate time.Time, csvPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.SheetsDeadline.Duration)
+	defer cancel()
+
+	var accounts []*Account
+	var err error
+	if cfg.AccountsFile != "" {
+		accounts, err = readAccountsFile(cfg.AccountsFile)
+	} else {
+		accounts, err = readAccounts(ctx, srv, cfg)
+	}
+	if err != nil {
+		return err
+	}
+	if csvPath != "" {
+		err = fillAccountsFromCSV(accounts, csvPath, runDate)
+	} else if err = fillAccountsFromHistory(accounts, history, runDate); err == nil {
+		err = fillAccountsFromNextTab(ctx, srv, cfg.SpreadsheetID, accounts, runDate)
+	}
+	if err != nil {
+		return err
+	}
+	captured := 0
+	for _, account := range accounts {
+		if account.CaptureErr == nil {
+			captured++
+		}
+	}
+	if captured == 0 {
+		return fmt.Errorf("no numbers recorded for %s", runDate.Format("2006-01-02"))
+	}
+	if err := computeChanges(history, accounts); err != nil {
+		return err
+	}
+
+	// The save state is only a fallback here; a rebuild never advances it.
+	state, err := newStateStore(cfg.StateFile).Load()
+	if err != nil {
+		return fmt.Errorf("Unable to load save state: %v", err)
+	}
+
+	title := sheetTitle(runDate)
+	existing, err := findSheet(ctx, srv, cfg.SpreadsheetID, title)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		if existing != nil {
+			fmt.Printf("Dry run; %q would be set aside during the rebuild and deleted after it\n", title)
+		}
+		return spreadSheetWork(ctx, srv, cfg, runDate, state, accounts)
+	}
+	if existing != nil {
+		if err := renameSheet(ctx, srv, cfg.SpreadsheetID, existing.SheetId, title+" (before rebuild)"); err != nil {
+			return fmt.Errorf("setting aside %q: %v", title, err)
+		}
+	}
+
+	if err := spreadSheetWork(ctx, srv, cfg, runDate, state, accounts); err != nil {
+		if existing != nil {
+			if restoreErr := renameSheet(ctx, srv, cfg.SpreadsheetID, existing.SheetId, title); restoreErr != nil {
+				log.Printf("Unable to restore the name of %q: %v", title, restoreErr)
+			}
+		}
+		return err
+	}
+
+	if existing != nil {
+		err := sheetsRetryPolicy.do(ctx, func() error {
+			_, err := srv.Spreadsheets.BatchUpdate(cfg.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+				Requests: []*sheets.Request{{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: existing.SheetId}}},
+			}).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rebuilt %q but could not delete the old tab: %v", title, err)
+		}
+	}
+	fmt.Printf("Rebuilt %q\n", title)
+	return nil
+}
+
+// Use each account's last good capture from the day of runDate. Accounts
+// without one are marked as failed.
+func fillAccountsFromHistory(accounts []*Account, history *HistoryStore, runDate time.Time) error {
+	for _, account := range accounts {
+		platform, _ := platformForURL(account.FullURL)
+		captures, err := history.History(platform, account.AccountName, runDate, runDate.AddDate(0, 0, 1))
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, c := range captures {
+			if c.Status == "" {
+				account.Followers = int(c.Followers)
+				account.Likes = int(c.Likes)
+				account.CapturedAt = c.CapturedAt
+				found = true
+			}
+		}
+		if !found {
+			account.CaptureErr = newScrapeError(ScrapeFailed, account, errors.New("no capture recorded that day"))
+		}
+	}
+	return nil
+}
+
+// Fill the accounts still marked as failed from the first dated tab after
+// runDate, whose follower and like blocks both have a column for runDate.
+// Rows are matched on the label in column B, since every tab is sorted
+// differently. Without such a tab the accounts are left as they are.
+func fillAccountsFromNextTab(ctx context.Context, srv *sheets.Service, spreadSheetID string, accounts []*Account, runDate time.Time) error {
+	var missing []*Account
+	for _, account := range accounts {
+		if account.CaptureErr != nil {
+			missing = append(missing, account)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var spreadsheet *sheets.Spreadsheet
+	err := sheetsRetryPolicy.do(ctx, func() (err error) {
+		spreadsheet, err = srv.Spreadsheets.Get(spreadSheetID).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reading spreadsheet: %v", err)
+	}
+	runDay, _ := time.Parse(tabDateLayout, runDate.Format(tabDateLayout))
+	var next string
+	var nextDate time.Time
+	for _, sh := range spreadsheet.Sheets {
+		date, ok := tabDate(sh.Properties.Title)
+		if ok && date.After(runDay) && (next == "" || date.Before(nextDate)) {
+			next, nextDate = sh.Properties.Title, date
+		}
+	}
+	if next == "" {
+		return nil
+	}
+
+	var resp *sheets.ValueRange
+	err = sheetsRetryPolicy.do(ctx, func() (err error) {
+		resp, err = srv.Spreadsheets.Values.Get(spreadSheetID, next+"!A:ZZ").Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("reading %q: %v", next, err)
+	}
+	// The follower section's header row comes first and the like section's
+	// second; each lists the dates of its first block before any other.
+	var sections []map[string]string
+	for i, row := range resp.Values {
+		column := -1
+		for j, cell := range row {
+			if date, ok := headerDate(fmt.Sprintf("%v", cell)); ok && date.Equal(runDay) {
+				column = j
+				break
+			}
+		}
+		if column == -1 {
+			continue
+		}
+		section := map[string]string{}
+		for _, labelRow := range resp.Values[i+1:] {
+			if len(labelRow) < 2 || fmt.Sprintf("%v", labelRow[1]) == "" {
+				break
+			}
+			if column < len(labelRow) {
+				section[strings.ToLower(fmt.Sprintf("%v", labelRow[1]))] = fmt.Sprintf("%v", labelRow[column])
+			}
+		}
+		if sections = append(sections, section); len(sections) == 2 {
+			break
+		}
+	}
+	if len(sections) != 2 {
+		log.Printf("%q has no follower and like columns for %s", next, runDate.Format(tabDateLayout))
+		return nil
+	}
+
+	for _, account := range missing {
+		label := strings.ToLower(account.Platform)
+		followers, err := ParseCount(sections[0][label], LocaleEnglish)
+		if err != nil {
+			continue
+		}
+		likes, err := ParseCount(sections[1][label], LocaleEnglish)
+		if err != nil {
+			continue
+		}
+		account.Followers = int(followers)
+		account.Likes = int(likes)
+		account.CapturedAt = runDate
+		account.CaptureErr = nil
+	}
+	return nil
+}
+
+// Read account,followers,likes rows; the first row is a header.
+func fillAccountsFromCSV(accounts []*Account, path string, runDate time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	if _, err := reader.Read(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	type counts struct{ followers, likes int }
+	values := map[string]counts{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("%s: expected account,followers,likes but got %d columns", path, len(record))
+		}
+		followers, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			return fmt.Errorf("%s: followers for %s: %v", path, record[0], err)
+		}
+		likes, err := strconv.Atoi(strings.TrimSpace(record[2]))
+		if err != nil {
+			return fmt.Errorf("%s: likes for %s: %v", path, record[0], err)
+		}
+		values[strings.ToLower(strings.TrimSpace(record[0]))] = counts{followers, likes}
+	}
+
+	for _, account := range accounts {
+		v, ok := values[strings.ToLower(account.AccountName)]
+		if !ok {
+			account.CaptureErr = newScrapeError(ScrapeFailed, account, fmt.Errorf("not in %s", path))
+			continue
+		}
+		account.Followers = v.followers
+		account.Likes = v.likes
+		account.CapturedAt = runDate
+	}
+	return nil
+}
+
+// The tab with the given title, or nil when there is none.
+func findSheet(ctx context.Context, srv *sheets.Service, spreadSheetID string, title string) (*sheets.SheetProperties, error) {
+	var spreadsheet *sheets.Spreadsheet
+	err := sheetsRetryPolicy.do(ctx, func() (err error) {
+		spreadsheet, err = srv.Spreadsheets.Get(spreadSheetID).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, sh := range spreadsheet.Sheets {
+		if sh.Properties.Title == title {
+			return sh.Properties, nil
+		}
+	}
+	return nil, nil
+}
+
+func renameSheet(ctx context.Context, srv *sheets.Service, spreadSheetID string, sheetID int64, title string) error {
+	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{
+			UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+				Properties: &sheets.SheetProperties{SheetId: sheetID, Title: title},
+				Fields:     "title",
+			},
+		}},
+	}
+	return sheetsRetryPolicy.do(ctx, func() error {
+		_, err := srv.Spreadsheets.BatchUpdate(spreadSheetID, batchReq).Context(ctx).Do()
+		return err
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func (r *testRun) rebuild() error {
+	return rebuild(r.cfg, r.fake.service(), r.history, testRunDate, "")
+}
+
+// The column for the tab's date, by label, from the follower section.
+func followersByLabel(tab *fakeTab) map[string]string {
+	labels, followers := tab.column("B", 3, 5), tab.column("F", 3, 5)
+	byLabel := map[string]string{}
+	for i, label := range labels {
+		byLabel[label] = followers[i]
+	}
+	return byLabel
+}
+
+func TestRebuildReplacesExistingTab(t *testing.T) {
+	r := newTestRun(t)
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	broken := r.fake.tab(testStatsID, testNew)
+	broken.set(2, 5, "garbage")
+	brokenID := broken.id
+
+	if err := r.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious, testNew)
+	tab := r.fake.tab(testStatsID, testNew)
+	if tab.id == brokenID {
+		t.Error("rebuild kept the old tab")
+	}
+	followers := followersByLabel(tab)
+	expectCells(t, "followers", []string{followers["Alpha"], followers["Beta"], followers["Gamma"]}, "1500", "3200", "ERROR: failed")
+}
+
+func TestRebuildRestoresTabOnFailure(t *testing.T) {
+	r := newTestRun(t)
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	oldID := r.fake.tab(testStatsID, testNew).id
+	r.fake.failRequest = func(req *sheets.Request) bool { return req.SortRange != nil }
+
+	if err := r.rebuild(); err == nil {
+		t.Fatal("rebuild succeeded despite the sort failing")
+	}
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious, testNew)
+	if id := r.fake.tab(testStatsID, testNew).id; id != oldID {
+		t.Errorf("%q is tab %d after the failed rebuild, want the original %d", testNew, id, oldID)
+	}
+}
+
+// With nothing in the history for the day, as for runs from before it was
+// kept, the numbers come from that date's column in the next dated tab.
+func TestRebuildReadsNextTab(t *testing.T) {
+	r := newTestRun(t)
+	header := []interface{}{"", "Account", "10/11/2026", "10/18/2026", "10/25/2026"}
+	r.fake.addTab(testStatsID, "10/25/2026 (S)", [][]interface{}{
+		{},
+		header,
+		{"1", "Beta", 3150.0, 3300.0, 3400.0},
+		{"2", "Alpha", 1000.0, 1600.0, 1700.0},
+		{"3", "Gamma", 30.0, "ERROR: private", "ERROR: private"},
+		{},
+		header,
+		{"1", "Alpha", 15000.0, 21000.0, 22000.0},
+		{"2", "Beta", 8800.0, 9500.0, 9600.0},
+		{"3", "Gamma", 300.0, "ERROR: private", "ERROR: private"},
+	})
+
+	if err := r.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	tab := r.fake.tab(testStatsID, testNew)
+	followers := followersByLabel(tab)
+	expectCells(t, "followers", []string{followers["Alpha"], followers["Beta"], followers["Gamma"]}, "1600", "3300", "ERROR: failed")
+	expectCells(t, "likes header", []string{tab.cell("F7")}, "10/18/2026")
+}
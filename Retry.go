@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+)
+
+// retryPolicy controls how Sheets API calls are retried. The overall time
+// allowed is set by the deadline on the context passed to do.
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Which errors are worth another attempt; retryableSheetsError if nil.
+	Retryable func(error) bool
+}
+
+var sheetsRetryPolicy = retryPolicy{MaxAttempts: 8, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+// Run call until it succeeds, fails with an error that is not worth
+// retrying, runs out of attempts, or the next wait would pass ctx's deadline.
+func (p retryPolicy) do(ctx context.Context, call func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = retryableSheetsError
+	}
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !retryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if after, ok := retryAfter(err); ok {
+			delay = after
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.Printf("Sheets call failed (attempt %d/%d), retrying in %v: %v", attempt, p.MaxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// Full jitter: a random wait up to BaseDelay doubled once per attempt.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << uint(attempt-1)
+	if ceiling > p.MaxDelay || ceiling <= 0 {
+		ceiling = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// Server errors and quota errors are worth retrying; everything else is not.
+func retryableSheetsError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return rejectedSheetsError(err)
+}
+
+// Quota errors, where the server turned the call away without acting on it,
+// so even a call that must not be made twice can be retried.
+func rejectedSheetsError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Errors that leave it unknown whether the call took effect: server errors,
+// and failures with no API response at all such as a dropped connection.
+func ambiguousSheetsError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.Code >= http.StatusInternalServerError
+}
+
+// How long the server asked us to wait, from a Retry-After header.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
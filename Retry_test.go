@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+)
+
+var testRetryPolicy = retryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// A stand-in for the Sheets API that answers each call with the next of
+// statuses, sending retryAfter with each, and with a spreadsheet once they
+// run out. It returns the number of calls made so far.
+func statusServer(t *testing.T, retryAfter string, statuses ...int) (*sheets.Service, func() int) {
+	t.Helper()
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[calls-1])
+			fmt.Fprintf(w, `{"error": {"code": %d, "message": "test"}}`, statuses[calls-1])
+			return
+		}
+		w.Write([]byte(`{"spreadsheetId": "test"}`))
+	}))
+	t.Cleanup(server.Close)
+	srv, err := sheets.New(server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BasePath = server.URL + "/"
+	return srv, func() int { return calls }
+}
+
+func getSpreadsheet(ctx context.Context, p retryPolicy, srv *sheets.Service) error {
+	return p.do(ctx, func() error {
+		_, err := srv.Spreadsheets.Get("test").Context(ctx).Do()
+		return err
+	})
+}
+
+func TestRetryWaitsAsTold(t *testing.T) {
+	srv, calls := statusServer(t, "1", http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	start := time.Now()
+	if err := getSpreadsheet(context.Background(), testRetryPolicy, srv); err != nil {
+		t.Fatal(err)
+	}
+	if calls() != 3 {
+		t.Errorf("%d calls, want 3", calls())
+	}
+	// Retry-After outranks the policy's millisecond backoff.
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %v, want the 2s Retry-After asked for", elapsed)
+	}
+}
+
+func TestRetryBacksOffWithoutRetryAfter(t *testing.T) {
+	srv, calls := statusServer(t, "", http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout)
+	if err := getSpreadsheet(context.Background(), testRetryPolicy, srv); err != nil {
+		t.Fatal(err)
+	}
+	if calls() != 4 {
+		t.Errorf("%d calls, want 4", calls())
+	}
+}
+
+func TestRetryGivesUpAtDeadline(t *testing.T) {
+	srv, calls := statusServer(t, "30", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := getSpreadsheet(ctx, testRetryPolicy, srv); err == nil {
+		t.Fatal("call succeeded despite the deadline")
+	}
+	if calls() != 1 {
+		t.Errorf("%d calls, want 1", calls())
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %v; a wait past the deadline should not be started", elapsed)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	srv, calls := statusServer(t, "", http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	if err := getSpreadsheet(context.Background(), testRetryPolicy, srv); err == nil {
+		t.Fatal("call succeeded after every attempt failed")
+	}
+	if calls() != testRetryPolicy.MaxAttempts {
+		t.Errorf("%d calls, want %d", calls(), testRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound} {
+		srv, calls := statusServer(t, "0", status)
+		if err := getSpreadsheet(context.Background(), testRetryPolicy, srv); err == nil {
+			t.Errorf("HTTP %d: call succeeded", status)
+		}
+		if calls() != 1 {
+			t.Errorf("HTTP %d: %d calls, want 1", status, calls())
+		}
+	}
+}
+
+// A batch that adds a tab is sent again when the server turned it away, but
+// not after a server error that may have come after the tab was added.
+func TestLiveWriterRetriesStructuralBatchesOnlyWhenRejected(t *testing.T) {
+	duplicate := []*sheets.Request{{DuplicateSheet: &sheets.DuplicateSheetRequest{SourceSheetId: 1, NewSheetName: "copy"}}}
+	tests := []struct {
+		status int
+		calls  int
+	}{
+		{http.StatusTooManyRequests, 2},
+		{http.StatusServiceUnavailable, 1},
+	}
+	for _, test := range tests {
+		srv, calls := statusServer(t, "0", test.status)
+		w := &liveWriter{srv: srv, spreadSheetID: "test"}
+		w.BatchUpdate(context.Background(), duplicate)
+		if calls() != test.calls {
+			t.Errorf("HTTP %d: %d calls, want %d", test.status, calls(), test.calls)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+)
+
+// sheetRun records which steps of spreadSheetWork have been applied so a
+// failed run can put the spreadsheet back the way it found it.
+type sheetRun struct {
+	sheet     *sheets.SheetProperties
+	completed []string
+	// Title of a tab whose creation failed in a way that may have created
+	// it anyway, to look for when rolling back
+	maybeCreated string
+}
+
+func (r *sheetRun) done(step string) {
+	r.completed = append(r.completed, step)
+}
+
+// Delete the tab this run created, unless keepPartial asks to leave it for
+// debugging, and return cause with any rollback failure added to it. The
+// rollback gets its own deadline since cause may be the run's running out.
+func (r *sheetRun) rollback(srv *sheets.Service, spreadSheetID string, keepPartial bool, cause error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if r.sheet == nil && r.maybeCreated != "" {
+		sheet, err := findSheet(ctx, srv, spreadSheetID, r.maybeCreated)
+		if err != nil {
+			return fmt.Errorf("%v; checking whether %q was created also failed: %v", cause, r.maybeCreated, err)
+		}
+		r.sheet = sheet
+	}
+	if r.sheet == nil {
+		return cause
+	}
+	steps := strings.Join(r.completed, ", ")
+	if keepPartial {
+		log.Printf("Keeping partial sheet %q (completed: %s)", r.sheet.Title, steps)
+		return cause
+	}
+
+	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: r.sheet.SheetId}},
+		},
+	}
+	err := sheetsRetryPolicy.do(ctx, func() error {
+		_, err := srv.Spreadsheets.BatchUpdate(spreadSheetID, batchReq).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%v; deleting partial sheet %q also failed: %v", cause, r.sheet.Title, err)
+	}
+	log.Printf("Deleted partial sheet %q (completed: %s)", r.sheet.Title, steps)
+	return cause
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tebeka/selenium"
+	"golang.org/x/net/context"
+)
+
+// Stats are the numbers a Scraper reads off a profile page.
+type Stats struct {
+	Followers int
+	Likes     int
+	Videos    int
+	Following int
+}
+
+// Scraper reads the current stats for an account on a single platform.
+type Scraper interface {
+	Scrape(ctx context.Context, account *Account) (Stats, error)
+}
+
+type scraperFactory func(fetcher pageFetcher) Scraper
+
+// Scrapers available per platform, filled in by each platform's init.
+var scraperRegistry = map[string]scraperFactory{}
+
+// Host suffixes for the platforms we know how to recognise.
+var platformHosts = map[string]string{
+	"tiktok.com":    "tiktok",
+	"instagram.com": "instagram",
+	"youtube.com":   "youtube",
+	"twitch.tv":     "twitch",
+}
+
+func registerScraper(platform string, factory scraperFactory) {
+	scraperRegistry[platform] = factory
+}
+
+// Build one Scraper per registered platform sharing the same fetcher.
+func newScrapers(fetcher pageFetcher) map[string]Scraper {
+	scrapers := map[string]Scraper{}
+	for platform, factory := range scraperRegistry {
+		scrapers[platform] = factory(fetcher)
+	}
+	return scrapers
+}
+
+// Work out which platform a profile URL belongs to from its host.
+// Account.Platform holds the row label used in the sheet, not the site.
+func platformForURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(u.Hostname())
+	for suffix, platform := range platformHosts {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return platform, nil
+		}
+	}
+	return "", fmt.Errorf("unknown platform for %s", rawURL)
+}
+
+// ScrapeErrorKind says why an account's stats could not be read.
+type ScrapeErrorKind int
+
+const (
+	ScrapeFailed ScrapeErrorKind = iota
+	ScrapeNotFound
+	ScrapePrivate
+	ScrapeLayoutChanged
+	ScrapeTimeout
+	ScrapeBlocked
+	// Not fetched because the platform's daily cap was used up
+	ScrapeCapReached
+	ScrapeCaptcha
+	ScrapeLoginWall
+	ScrapeRegionBlocked
+	ScrapeRateLimited
+)
+
+func (k ScrapeErrorKind) String() string {
+	switch k {
+	case ScrapeNotFound:
+		return "not found"
+	case ScrapePrivate:
+		return "private"
+	case ScrapeLayoutChanged:
+		return "layout changed"
+	case ScrapeTimeout:
+		return "timeout"
+	case ScrapeBlocked:
+		return "blocked"
+	case ScrapeCapReached:
+		return "daily cap reached"
+	case ScrapeCaptcha:
+		return "captcha"
+	case ScrapeLoginWall:
+		return "login wall"
+	case ScrapeRegionBlocked:
+		return "region blocked"
+	case ScrapeRateLimited:
+		return "rate limited"
+	default:
+		return "failed"
+	}
+}
+
+// Whether the site is pushing back on us rather than on the account, so its
+// domain should be left alone for a while.
+func (k ScrapeErrorKind) blocking() bool {
+	return k == ScrapeBlocked || k == ScrapeCaptcha || k == ScrapeRateLimited
+}
+
+// ScrapeError is returned by captureData when an account could not be scraped.
+type ScrapeError struct {
+	Kind    ScrapeErrorKind
+	Account string
+	Err     error
+}
+
+func (e *ScrapeError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Account, e.Kind)
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Account, e.Kind, e.Err)
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+// Text written to the sheet in place of a number for a failed account.
+func (e *ScrapeError) Status() string {
+	return "ERROR: " + e.Kind.String()
+}
+
+func newScrapeError(kind ScrapeErrorKind, account *Account, err error) *ScrapeError {
+	return &ScrapeError{Kind: kind, Account: account.AccountName, Err: err}
+}
+
+// Classify a WebDriver error as a timeout, or as kind when it is anything else.
+func driverError(kind ScrapeErrorKind, account *Account, err error) *ScrapeError {
+	if e, ok := err.(*selenium.Error); ok && strings.Contains(e.Err, "timeout") {
+		kind = ScrapeTimeout
+	}
+	return newScrapeError(kind, account, err)
+}
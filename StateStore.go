@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Current layout of the save state file. Files written before the version
+// field existed decode as version 0 and are upgraded on load.
+const stateVersion = 1
+
+// StateStore reads and writes the UpdateState kept between runs.
+type StateStore struct {
+	path string
+}
+
+func newStateStore(path string) *StateStore {
+	return &StateStore{path: path}
+}
+
+// Load the saved state, upgrading older files to the current version.
+func (s *StateStore) Load() (*UpdateState, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var state UpdateState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return nil, fmt.Errorf("%s: %v", s.path, err)
+	}
+	switch {
+	case state.Version == 0:
+		state.Version = stateVersion
+	case state.Version > stateVersion:
+		return nil, fmt.Errorf("%s: state version %d is newer than supported version %d", s.path, state.Version, stateVersion)
+	}
+	return &state, nil
+}
+
+// Save writes the state to a temporary file next to the real one and renames
+// it into place, so a crash mid-write never leaves a truncated file behind.
+func (s *StateStore) Save(state *UpdateState) error {
+	state.Version = stateVersion
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(state); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tebeka/selenium"
+	"golang.org/x/net/context"
+)
+
+const (
+	tikTokFollowersXpath = "/html/body/div[1]/div/div[2]/div/div[1]/div/header/h2[1]/div[2]/strong"
+	tikTokLikesXpath     = "/html/body/div[1]/div/div[2]/div/div[1]/div/header/h2[1]/div[3]/strong"
+)
+
+type tikTokScraper struct {
+	fetcher pageFetcher
+}
+
+func init() {
+	registerScraper("tiktok", newTikTokScraper)
+}
+
+func newTikTokScraper(fetcher pageFetcher) Scraper {
+	return &tikTokScraper{fetcher: fetcher}
+}
+
+func (s *tikTokScraper) Scrape(ctx context.Context, account *Account) (Stats, error) {
+	source, err := s.fetcher.Fetch(ctx, account)
+	if err != nil {
+		return Stats{}, err
+	}
+	if kind, ok := classifyPage(source, tikTokBlockClasses); ok {
+		return Stats{}, newScrapeError(kind, account, nil)
+	}
+	stats, err := extractTikTokStats(source, account.AccountName)
+	if err == nil {
+		return stats, nil
+	}
+	// Private profiles still show their counts, so these only explain a
+	// page the counts could not be read from.
+	kind, classified := classifyPage(source, tikTokProfileClasses)
+	if classified && kind != ScrapePrivate {
+		return Stats{}, newScrapeError(kind, account, nil)
+	}
+
+	// Without a browser there is no rendered DOM to fall back on.
+	browser, ok := s.fetcher.(*seleniumFetcher)
+	if !ok {
+		if classified {
+			return Stats{}, newScrapeError(kind, account, nil)
+		}
+		return Stats{}, newScrapeError(ScrapeLayoutChanged, account, err)
+	}
+	log.Printf("Falling back to XPath for %s: %v", account.AccountName, err)
+	stats, err = tikTokXPathStats(browser.driver, account)
+	if err != nil && classified {
+		return Stats{}, newScrapeError(kind, account, nil)
+	}
+	return stats, err
+}
+
+// Read the abbreviated counts from the rendered profile header.
+func tikTokXPathStats(driver selenium.WebDriver, account *Account) (Stats, error) {
+	var stats Stats
+	driver.SetImplicitWaitTimeout(time.Second * 30)
+
+	followers, err := driver.FindElement(selenium.ByXPATH, tikTokFollowersXpath)
+	if err != nil {
+		return stats, driverError(ScrapeLayoutChanged, account, err)
+	}
+	likes, err := driver.FindElement(selenium.ByXPATH, tikTokLikesXpath)
+	if err != nil {
+		return stats, driverError(ScrapeLayoutChanged, account, err)
+	}
+
+	followerCount, err := followers.Text()
+	if err != nil {
+		return stats, driverError(ScrapeLayoutChanged, account, err)
+	}
+	likeCount, err := likes.Text()
+	if err != nil {
+		return stats, driverError(ScrapeLayoutChanged, account, err)
+	}
+	followerNumber, err := ParseCount(followerCount, LocaleEnglish)
+	if err != nil {
+		return stats, newScrapeError(ScrapeLayoutChanged, account, err)
+	}
+	likeNumber, err := ParseCount(likeCount, LocaleEnglish)
+	if err != nil {
+		return stats, newScrapeError(ScrapeLayoutChanged, account, err)
+	}
+	stats.Followers = int(followerNumber)
+	stats.Likes = int(likeNumber)
+	return stats, nil
+}
+
+// Pull exact counts for uniqueID out of the JSON TikTok embeds in its
+// profile pages, trying the current __UNIVERSAL_DATA_FOR_REHYDRATION__
+// script first and the older SIGI_STATE one second.
+func extractTikTokStats(source string, uniqueID string) (Stats, error) {
+	if blob, ok := scriptJSON(source, "__UNIVERSAL_DATA_FOR_REHYDRATION__"); ok {
+		var data struct {
+			DefaultScope struct {
+				UserDetail struct {
+					UserInfo struct {
+						Stats tikTokStats `json:"stats"`
+					} `json:"userInfo"`
+				} `json:"webapp.user-detail"`
+			} `json:"__DEFAULT_SCOPE__"`
+		}
+		if err := json.Unmarshal([]byte(blob), &data); err != nil {
+			return Stats{}, fmt.Errorf("decoding __UNIVERSAL_DATA_FOR_REHYDRATION__: %v", err)
+		}
+		if stats := data.DefaultScope.UserDetail.UserInfo.Stats; stats.present() {
+			return stats.toStats(), nil
+		}
+	}
+	if blob, ok := scriptJSON(source, "SIGI_STATE"); ok {
+		var data struct {
+			UserModule struct {
+				Stats map[string]tikTokStats `json:"stats"`
+			} `json:"UserModule"`
+		}
+		if err := json.Unmarshal([]byte(blob), &data); err != nil {
+			return Stats{}, fmt.Errorf("decoding SIGI_STATE: %v", err)
+		}
+		for id, stats := range data.UserModule.Stats {
+			if strings.EqualFold(id, uniqueID) {
+				return stats.toStats(), nil
+			}
+		}
+	}
+	return Stats{}, fmt.Errorf("no embedded stats for %s", uniqueID)
+}
+
+type tikTokStats struct {
+	FollowerCount  *int64 `json:"followerCount"`
+	FollowingCount int64  `json:"followingCount"`
+	HeartCount     int64  `json:"heartCount"`
+	VideoCount     int64  `json:"videoCount"`
+}
+
+func (t tikTokStats) present() bool {
+	return t.FollowerCount != nil
+}
+
+func (t tikTokStats) toStats() Stats {
+	stats := Stats{
+		Likes:     int(t.HeartCount),
+		Videos:    int(t.VideoCount),
+		Following: int(t.FollowingCount),
+	}
+	if t.FollowerCount != nil {
+		stats.Followers = int(*t.FollowerCount)
+	}
+	return stats
+}
+
+// Return the body of the <script id="id"> element in an HTML page.
+func scriptJSON(source string, id string) (string, bool) {
+	start := strings.Index(source, `id="`+id+`"`)
+	if start == -1 {
+		return "", false
+	}
+	open := strings.Index(source[start:], ">")
+	if open == -1 {
+		return "", false
+	}
+	body := source[start+open+1:]
+	end := strings.Index(body, "</script>")
+	if end == -1 {
+		return "", false
+	}
+	return strings.TrimSpace(body[:end]), true
+}
+
+// The pages TikTok serves instead of a profile, checked before reading the
+// counts. Captcha and rate limit pages come first since they can wrap a
+// profile that would otherwise match.
+var tikTokBlockClasses = []pageClass{
+	{kind: ScrapeCaptcha, markers: []string{"captcha_container", "verify-bar-close", "secsdk-captcha", "captcha-verify-image"}},
+	{kind: ScrapeRateLimited, markers: []string{"Too many attempts. Try again later.", "Maximum number of attempts reached"}},
+	{kind: ScrapeRegionBlocked, markers: []string{"isn't available in your region", "not available in your country or region"}},
+	{kind: ScrapeBlocked, titles: []string{"Something went wrong"}, markers: []string{"Sorry about that! Please try again later."}},
+}
+
+// Why a profile page had no counts to read. A private profile's page says
+// so but still carries them, so these are only checked once reading fails.
+var tikTokProfileClasses = []pageClass{
+	{kind: ScrapeLoginWall, titles: []string{"Log in | TikTok"}},
+	{kind: ScrapeNotFound, markers: []string{"Couldn't find this account"}},
+	{kind: ScrapePrivate, markers: []string{"This account is private"}},
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, fixture string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExtractTikTokStats(t *testing.T) {
+	tests := []struct {
+		fixture string
+		id      string
+		want    Stats
+	}{
+		{"alpha.html", "alpha", Stats{Followers: 1500, Likes: 20000, Videos: 48, Following: 12}},
+		{"beta.html", "beta", Stats{Followers: 3200, Likes: 9000, Videos: 15, Following: 7}},
+		// The sheet's URL need not match the case TikTok keys the stats by.
+		{"sigi-mixedcase.html", "delta", Stats{Followers: 41000, Likes: 1200000, Videos: 210, Following: 3}},
+		{"privatestats.html", "privatestats", Stats{Followers: 870, Likes: 5200, Videos: 9, Following: 40}},
+	}
+	for _, test := range tests {
+		got, err := extractTikTokStats(readFixture(t, "tiktok/"+test.fixture), test.id)
+		if err != nil {
+			t.Errorf("%s: %v", test.fixture, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.fixture, got, test.want)
+		}
+	}
+}
+
+func TestExtractTikTokStatsFailures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		id      string
+		want    string
+	}{
+		// Zero followers would pass for a real count, so no count is an error.
+		{"nofollowers.html", "epsilon", "no embedded stats for epsilon"},
+		{"malformed.html", "zeta", "decoding __UNIVERSAL_DATA_FOR_REHYDRATION__"},
+		{"beta.html", "someone-else", "no embedded stats for someone-else"},
+	}
+	for _, test := range tests {
+		_, err := extractTikTokStats(readFixture(t, "tiktok/"+test.fixture), test.id)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want %q", test.fixture, err, test.want)
+		}
+	}
+}
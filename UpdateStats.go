@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"image"
-	"image/png"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -15,10 +14,6 @@ import (
 	"strings"
 	"time"
 
-	// Selenium
-	"github.com/tebeka/selenium"
-	"github.com/tebeka/selenium/chrome"
-
 	// Google Sheets
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -26,143 +21,167 @@ import (
 	"google.golang.org/api/sheets/v4"
 
 	// Excel Functions
-	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
 )
 
-var screenshotDir = "screenshots"
-
 type UpdateState struct {
+	Version int
 	// Update State
 	FirstBlockStart, SecondBlockStart, ThirdBlockStart int64
+	// Date (2006-01-02) of the last run that updated the sheet
+	LastRunDate string
 }
 
+// The time captures are stamped with; tests pin it to a fixed date.
+var clock = time.Now
+
 type Account struct {
 	Platform    string
 	Followers   int
 	Likes       int
+	Videos      int
+	Following   int
 	AccountName string
+	// Week-over-week follower change, abbreviated, for the exports
 	Difference  string
 	SheetRowNum int
 	CountNum    int
 	FullURL     string
+	CapturedAt  time.Time
+	CaptureErr  *ScrapeError
+	// Screenshot taken while capturing, if the fetcher saves them
+	ScreenshotPath string
+	// Proxy the profile was fetched through, if any
+	proxy *proxy
+
+	// Change since the previous run and since four weeks ago
+	WeekChange, FourWeekChange Changes
 }
 
-type Converter struct {
-	Divider int64
-	Suffix  string
-}
-
-func captureData(account *Account, driver selenium.WebDriver, screenshotPath string) {
-	url := account.FullURL
-	accountName := account.AccountName
-
-	if err := driver.Get(url); err != nil {
-		fmt.Println(err)
-		log.Fatal(err)
+// Set up the Sheets API client from the configured credentials and token.
+func newSheetsService(cfg *Config) (*sheets.Service, error) {
+	b, err := ioutil.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read client secret file: %v", err)
 	}
 
-	// Save screenshot
-	pngBytes, err := driver.Screenshot()
-
-	img, _, _ := image.Decode(bytes.NewReader(pngBytes))
-	fileName := accountName + ".png"
-	fullPath := filepath.Join(screenshotPath, fileName)
-	outFile, err := os.Create(fullPath)
-	defer outFile.Close()
-	err = png.Encode(outFile, img)
-
-	driver.SetImplicitWaitTimeout(time.Second * 30)
-
-	followersXpath := "/html/body/div[1]/div/div[2]/div/div[1]/div/header/h2[1]/div[2]/strong"
-	likesXpath := "/html/body/div[1]/div/div[2]/div/div[1]/div/header/h2[1]/div[3]/strong"
-
-	followers, err := driver.FindElement(selenium.ByXPATH, followersXpath)
-	errChk(err)
-
-	likes, err := driver.FindElement(selenium.ByXPATH, likesXpath)
-	errChk(err)
-
-	followerCount, err := followers.Text()
-	likeCount, err := likes.Text()
-	followerNumber := convertCountToNumber(followerCount)
-	likesNumber := convertCountToNumber(likeCount)
-
-	account.Followers = followerNumber
-	account.Likes = likesNumber
-}
+	// If modifying these scopes, delete your previously saved token.json.
+	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/spreadsheets", "https://www.googleapis.com/auth/drive", "https://www.googleapis.com/auth/drive.file")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse client secret file to config: %v", err)
+	}
+	client, err := getClient(config, cfg.TokenFile)
+	if err != nil {
+		return nil, err
+	}
 
-func errChk(err error) {
+	srv, err := sheets.New(client)
 	if err != nil {
-		fmt.Println(err)
-		log.Fatal(err)
+		return nil, fmt.Errorf("Unable to retrieve Sheets client: %v", err)
 	}
+	return srv, nil
 }
 
-func convertCountToNumber(count string) int {
-	switch unit := count[len(count)-1:]; unit {
-	case "M":
-		period := strings.Index(count, ".")
-		beforePeriodDigits := ""
-		afterPeriodDigits := ""
-		if period == -1 {
-			beforePeriodDigits = count[:strings.Index(count, "M")]
-			afterPeriodDigits = ""
-		} else {
-			beforePeriodDigits = count[:period]
-			afterPeriodDigits = count[period+1 : len(count)-1]
-		}
-		numberOfDigitsAfter := len(afterPeriodDigits)
-		zeroes := strings.Repeat("0", 6-numberOfDigitsAfter)
-		newNumber := fmt.Sprintf("%s%s%s", beforePeriodDigits, afterPeriodDigits, zeroes)
-		returnNumber, _ := strconv.Atoi(newNumber)
-		return returnNumber
-	case "K":
-		number := count[:strings.Index(count, "K")]
-		period := strings.Index(count, ".")
-		var numberBefore, numberAfter, combined string
-		if period == -1 {
-			period = 1
-			numberBefore = number
-			numberAfter = ""
-		} else {
-			numberBefore = count[:period]
-			numberAfter = count[period+1 : strings.Index(count, "K")]
-		}
-		if numberAfter == "" {
-			combined = numberBefore
-		} else {
-			combined = fmt.Sprintf("%s%s", numberBefore, numberAfter)
-		}
-		zeroes := strings.Repeat("0", 3-len(numberAfter))
-		newNumber := combined + zeroes
-		returnNumber, _ := strconv.Atoi(newNumber)
-		return returnNumber
-	default:
-		returnNumber, _ := strconv.Atoi(count)
-		return returnNumber
+// Read the accounts to scrape from the URL sheet. The list starts at the row
+// numbered 1 in the first column and runs until the numbering stops.
+func readAccounts(ctx context.Context, srv *sheets.Service, cfg *Config) ([]*Account, error) {
+	batchGetCall := srv.Spreadsheets.Values.Get(cfg.URLSpreadsheetID, cfg.URLRange)
+	batchGetCall = batchGetCall.MajorDimension("COLUMNS")
+
+	var resp *sheets.ValueRange
+	err := sheetsRetryPolicy.do(ctx, func() (err error) {
+		resp, err = batchGetCall.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return accountsFromColumns(cfg.URLRange, resp.Values)
 }
 
-// Save state from File
-func loadSaveStateFromFile(file string) (*UpdateState, error) {
-	var state UpdateState
-	f, err := os.Open(file)
+// Read the account table from a CSV file laid out like the URL range.
+func readAccountsFile(path string) ([]*Account, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	err = json.NewDecoder(f).Decode(&state)
-	return &state, err
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	columns := make([][]interface{}, 3)
+	for _, record := range records {
+		for i := range columns {
+			value := ""
+			if i < len(record) {
+				value = strings.TrimSpace(record[i])
+			}
+			columns[i] = append(columns[i], value)
+		}
+	}
+	return accountsFromColumns(path, columns)
 }
 
-func saveSaveStateToFile(path string, saveState *UpdateState) {
-	fmt.Printf("Saving saveState file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// Build the accounts from the number, label and URL columns of the account
+// table. Accounts start at the row numbered 1 and end at the first blank one.
+func accountsFromColumns(source string, columns [][]interface{}) ([]*Account, error) {
+	if len(columns) < 3 {
+		return nil, fmt.Errorf("%s: expected 3 columns of accounts, got %d", source, len(columns))
+	}
+
+	lookingForSpace := false
+	accounts := []*Account{}
+	for rowNum, row := range columns[0] {
+		rowValue := fmt.Sprintf("%v", row)
+		if rowValue == "1" {
+			lookingForSpace = true
+		}
+		if lookingForSpace {
+			fullURL := fmt.Sprintf("%s", columns[2][rowNum])
+			accountNumber, _ := strconv.Atoi(rowValue)
+			newAccount := &Account{
+				CountNum:    accountNumber,
+				SheetRowNum: rowNum + 1,
+				Platform:    fmt.Sprintf("%s", columns[1][rowNum]),
+				AccountName: fullURL[strings.Index(fullURL, "@")+1 : len(fullURL)],
+				FullURL:     fullURL,
+			}
+			accounts = append(accounts, newAccount)
+		}
+		if lookingForSpace && rowValue == "" {
+			break
+		}
+	}
+	return accounts, nil
+}
+
+// Scrape an account with the scraper for its platform and record the stats.
+// Any failure is returned as a *ScrapeError.
+func captureData(ctx context.Context, account *Account, scrapers map[string]Scraper) error {
+	platform, err := platformForURL(account.FullURL)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return newScrapeError(ScrapeFailed, account, err)
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(saveState)
+	scraper, ok := scrapers[platform]
+	if !ok {
+		return newScrapeError(ScrapeFailed, account, fmt.Errorf("no scraper registered for platform %q", platform))
+	}
+	account.CapturedAt = clock()
+	stats, err := scraper.Scrape(ctx, account)
+	if err != nil {
+		if _, ok := err.(*ScrapeError); ok {
+			return err
+		}
+		return newScrapeError(ScrapeFailed, account, err)
+	}
+	account.Followers = stats.Followers
+	account.Likes = stats.Likes
+	account.Videos = stats.Videos
+	account.Following = stats.Following
+	return nil
 }
 
 // Retrieves a token from a local file.
@@ -178,49 +197,52 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 }
 
 // Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
+func saveToken(path string, token *oauth2.Token) error {
 	fmt.Printf("Saving credential file to: %s\n", path)
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return fmt.Errorf("Unable to cache oauth token: %v", err)
 	}
 	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return json.NewEncoder(f).Encode(token)
 }
 
 // Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
+func getClient(config *oauth2.Config, tokFile string) (*http.Client, error) {
+	// The token file stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
 	// time.
-	tokFile := "token.json"
 	tok, err := tokenFromFile(tokFile)
 	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+		if tok, err = getTokenFromWeb(config); err != nil {
+			return nil, err
+		}
+		if err := saveToken(tokFile, tok); err != nil {
+			return nil, err
+		}
 	}
-	return config.Client(context.Background(), tok)
+	return config.Client(context.Background(), tok), nil
 }
 
 // Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		return nil, fmt.Errorf("Unable to read authorization code: %v", err)
 	}
 
 	tok, err := config.Exchange(context.TODO(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		return nil, fmt.Errorf("Unable to retrieve token from web: %v", err)
 	}
-	return tok
+	return tok, nil
 }
 
-func duplicateSheet(srv *sheets.Service, spreadSheetID string, newSheetName string, sheetID int64, insertIndex int64) (newSheetID sheets.SheetProperties) {
+func duplicateSheet(ctx context.Context, writer sheetWriter, newSheetName string, sheetID int64, insertIndex int64) (*sheets.SheetProperties, error) {
 	duplicateSheetRequest := sheets.DuplicateSheetRequest{
 		NewSheetName:     newSheetName,
 		SourceSheetId:    sheetID,
@@ -233,67 +255,65 @@ func duplicateSheet(srv *sheets.Service, spreadSheetID string, newSheetName stri
 	requests := []*sheets.Request{}
 	requests = append(requests, &dupSheetRequest)
 
-	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: requests,
+	resp, err := writer.BatchUpdate(ctx, requests)
+	if err != nil {
+		return nil, err
 	}
+	return resp.Replies[0].DuplicateSheet.Properties, nil
+}
 
-	resp, err := srv.Spreadsheets.BatchUpdate(spreadSheetID, batchReq).Do()
-	if err != nil {
-		log.Fatal(err)
+// A single cell update for a values batch.
+func cellValue(sheetTitle string, column string, row int64, value interface{}) *sheets.ValueRange {
+	return &sheets.ValueRange{
+		Range:          fmt.Sprintf("%s!%s%d", sheetTitle, column, row),
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{value}},
 	}
-	newSheetID = *resp.Replies[0].DuplicateSheet.Properties
-	return newSheetID
 }
 
-func differenceFormat(difference string) (formattedString string) {
-	numberOfZeroes := 0
-	newString := difference
-	for true {
-		innerString := strings.TrimSuffix(newString, "0")
-		if newString == innerString {
-			break
-		} else {
-			numberOfZeroes++
-			newString = innerString
-		}
-	}
-	switch fullLength := len(difference); {
-	case fullLength >= 10:
-		// fmt.Println("Billion")
-	case fullLength >= 7:
-		if len(newString) <= 1 {
-			formattedString = newString + "M"
-		} else {
-			formattedString = newString[0:1] + "." + newString[1:] + "M"
-		}
-	case fullLength >= 4:
-		if len(newString) <= 1 {
-			formattedString = newString + "00K"
-		} else {
-			switch thousandLength := len(newString); {
-			case thousandLength == 4 && numberOfZeroes == 1:
-				formattedString = newString[0:2] + "." + newString[2:] + "K"
-			case thousandLength == 4 && numberOfZeroes == 2:
-				formattedString = newString[0:3] + "." + newString[3:] + "K"
-			case thousandLength < 4:
-				formattedString = difference
+// The status of each failed account, written into the column on its
+// follower and likes rows.
+func statusValues(sheetTitle string, column string, upperHeaderRowNumber int64, lowerHeaderRowNumber int64, followerRows [][]interface{}, likeRows [][]interface{}, accounts []*Account) []*sheets.ValueRange {
+	var data []*sheets.ValueRange
+	for i, row := range followerRows {
+		for _, account := range accounts {
+			if len(row) > 0 && account.Platform == row[0] && account.CaptureErr != nil {
+				data = append(data, cellValue(sheetTitle, column, upperHeaderRowNumber+1+int64(i), account.CaptureErr.Status()))
 			}
 		}
 	}
-	return formattedString
+	for i, row := range likeRows {
+		for _, account := range accounts {
+			if len(row) > 0 && account.Platform == row[0] && account.CaptureErr != nil {
+				data = append(data, cellValue(sheetTitle, column, lowerHeaderRowNumber+1+int64(i), account.CaptureErr.Status()))
+			}
+		}
+	}
+	return data
+}
+
+// Read the account labels in column B of the follower and likes sections.
+func readAccountLabels(ctx context.Context, srv *sheets.Service, spreadSheetID string, sheetTitle string, upperHeaderRowNumber int64, lowerHeaderRowNumber int64, numberOfAccounts int64) (followerRows [][]interface{}, likeRows [][]interface{}, err error) {
+	followerReadRange := fmt.Sprintf("%s!B%d:B%d", sheetTitle, upperHeaderRowNumber+1, upperHeaderRowNumber+numberOfAccounts)
+	likesReadRange := fmt.Sprintf("%s!B%d:B%d", sheetTitle, lowerHeaderRowNumber+1, lowerHeaderRowNumber+numberOfAccounts)
+	var readResp *sheets.BatchGetValuesResponse
+	err = sheetsRetryPolicy.do(ctx, func() (err error) {
+		readResp, err = srv.Spreadsheets.Values.BatchGet(spreadSheetID).Ranges(followerReadRange, likesReadRange).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading account labels: %v", err)
+	}
+	if len(readResp.ValueRanges) != 2 {
+		return nil, nil, fmt.Errorf("expected 2 label ranges from %s, got %d", sheetTitle, len(readResp.ValueRanges))
+	}
+	return readResp.ValueRanges[0].Values, readResp.ValueRanges[1].Values, nil
 }
 
-func spreadSheetWork(srv *sheets.Service, newSheetName string, oldSheetName string, dateFormat string, state *UpdateState, accounts []*Account) {
-	//spreadSheetID := "1GRXYwIcmA2fQbOqr4ihO_4MY9Ok80-Su7ib2B7YV1lo"
-	spreadSheetID := "1ddu6XIRM7tajfJM0jdPVs0xvy2oKwe_M2Y9g5vcask4"
-	var sheetID int64 = 2031708574
-	conversionMatrix := []Converter{}
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e3, Suffix: "K"})
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e6, Suffix: "M"})
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e9, Suffix: "G"})
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e12, Suffix: "T"})
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e15, Suffix: "P"})
-	conversionMatrix = append(conversionMatrix, Converter{Divider: 1e18, Suffix: "E"})
+func spreadSheetWork(ctx context.Context, srv *sheets.Service, cfg *Config, runDate time.Time, state *UpdateState, accounts []*Account) (err error) {
+	spreadSheetID := cfg.SpreadsheetID
+	newSheetName := sheetTitle(runDate)
+	dateFormat := runDate.Format(tabDateLayout)
 
 	// Let's do some math on where things are going to go.
 	numberOfAccounts := int64(len(accounts))
@@ -303,24 +323,104 @@ func spreadSheetWork(srv *sheets.Service, newSheetName string, oldSheetName stri
 
 	requests := []*sheets.Request{}
 
-	spreadSheetsCall, err := srv.Spreadsheets.Get(spreadSheetID).Do()
+	var spreadSheetsCall *sheets.Spreadsheet
+	err = sheetsRetryPolicy.do(ctx, func() (err error) {
+		spreadSheetsCall, err = srv.Spreadsheets.Get(spreadSheetID).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("reading spreadsheet: %v", err)
 	}
 	spreadSheets := spreadSheetsCall.Sheets
-
-	var oldSheetIndex int64
+	// Rolling back a duplicate that may or may not have gone through deletes
+	// the tab by title, which must not find one from an earlier run. A dry
+	// run never duplicates, and a dry-run rebuild leaves the old tab in place.
+	if !cfg.DryRun {
+		for _, sh := range spreadSheets {
+			if sh.Properties.Title == newSheetName {
+				return fmt.Errorf("a tab named %q already exists; delete it or run rebuild for that date", newSheetName)
+			}
+		}
+	}
 
 	// Get the index and the sheetID from the last run
-	for _, sh := range spreadSheets {
-		if sh.Properties.Title == oldSheetName {
-			oldSheetIndex = sh.Properties.Index + 1
-			sheetID = sh.Properties.SheetId
+	oldSheet, err := findPreviousSheet(spreadSheets, cfg.FromSheet, runDate)
+	if err != nil {
+		return err
+	}
+	sheetID := oldSheet.SheetId
+	oldSheetIndex := oldSheet.Index + 1
+	sourceSheetTitle := oldSheet.Title
+
+	// A run earlier today saved offsets already advanced past the columns
+	// this rerun writes, so step back to the ones that run used.
+	if state.LastRunDate == runDate.Format("2006-01-02") {
+		state.FirstBlockStart--
+		state.SecondBlockStart--
+		state.ThirdBlockStart--
+	}
+
+	// Find the block columns from the sheet itself, using the save state only
+	// when the headers cannot be read.
+	discovered, err := discoverBlocks(ctx, srv, spreadSheetID, sourceSheetTitle, upperHeaderRowNumber, lowerHeaderRowNumber)
+	if _, ok := err.(*layoutError); ok {
+		return err
+	}
+	if err != nil {
+		log.Printf("Unable to find block columns, using save state: %v", err)
+	} else {
+		if discovered.FirstBlockStart != state.FirstBlockStart || discovered.SecondBlockStart != state.SecondBlockStart {
+			log.Printf("Save state blocks %d/%d/%d differ from sheet, using %d/%d/%d",
+				state.FirstBlockStart, state.SecondBlockStart, state.ThirdBlockStart,
+				discovered.FirstBlockStart, discovered.SecondBlockStart, discovered.ThirdBlockStart)
+		}
+		state.FirstBlockStart = discovered.FirstBlockStart
+		state.SecondBlockStart = discovered.SecondBlockStart
+		if discovered.ThirdBlockStart != 0 {
+			state.ThirdBlockStart = discovered.ThirdBlockStart
 		}
 	}
 
+	// The changes go in columns of their own, found by their header once a
+	// run has added them. Everything from the follower block on moves right
+	// one when this run's column goes in, so the same goes for them.
+	deltaColumn, deltaFound, err := locateDeltaBlock(ctx, srv, spreadSheetID, sourceSheetTitle, upperHeaderRowNumber, lowerHeaderRowNumber)
+	if err != nil {
+		return err
+	}
+	deltaColumn++
+
+	// A dry run records the changes and reads the old tab in place of the
+	// new one, which it never creates.
+	var writer sheetWriter = &liveWriter{srv: srv, spreadSheetID: spreadSheetID}
+	run := &sheetRun{}
+	if cfg.DryRun {
+		plan := newPlanWriter(spreadSheets)
+		defer plan.print(os.Stdout)
+		writer = plan
+	} else {
+		// Undo everything by deleting the new tab if a later step fails
+		defer func() {
+			if err != nil {
+				err = run.rollback(srv, spreadSheetID, cfg.KeepPartial, err)
+			}
+		}()
+	}
+
 	// First duplicate sheet
-	newSheet := duplicateSheet(srv, spreadSheetID, newSheetName, sheetID, oldSheetIndex)
+	newSheet, err := duplicateSheet(ctx, writer, newSheetName, sheetID, oldSheetIndex)
+	if err != nil {
+		if ambiguousSheetsError(err) {
+			run.maybeCreated = newSheetName
+		}
+		return fmt.Errorf("duplicating sheet %d as %q: %v", sheetID, newSheetName, err)
+	}
+	run.sheet = newSheet
+	run.done("duplicate")
+	labelSheetTitle := newSheet.Title
+	if cfg.DryRun {
+		labelSheetTitle = sourceSheetTitle
+	}
 
 	// Insert new first section column
 	firstSectionColumnInsert := sheets.DimensionRange{
@@ -356,46 +456,12 @@ func spreadSheetWork(srv *sheets.Service, newSheetName string, oldSheetName stri
 	hideColumnRequest := sheets.Request{
 		UpdateDimensionProperties: &hideRequest,
 	}
-	requests = []*sheets.Request{}
-	requests = append(requests, &insertColumnFirstSectionRequest)
-	requests = append(requests, &hideColumnRequest)
-
-	batchReq := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: requests,
-	}
-
-	_, err = srv.Spreadsheets.BatchUpdate(spreadSheetID, batchReq).Do()
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Update Header Values
-	// Followers
-	var values sheets.ValueRange
-	value := []interface{}{dateFormat}
-	values.Values = append(values.Values, value)
-	values.MajorDimension = "ROWS"
 
 	newColumn := state.FirstBlockStart + 1
 	newColumnName, err := excelize.ColumnNumberToName(int(newColumn))
-
-	followersUpdateCell := fmt.Sprintf("%s!%s%d:%s%d", newSheet.Title, newColumnName, upperHeaderRowNumber, newColumnName, upperHeaderRowNumber)
-	values.Range = followersUpdateCell
-
-	updateCall := srv.Spreadsheets.Values.Update(spreadSheetID, followersUpdateCell, &values)
-	updateCall.ValueInputOption("USER_ENTERED")
-
-	_, err = updateCall.Do()
-
-	// Likes
-	likesUpdateCell := fmt.Sprintf("%s!%s%d:%s%d", newSheet.Title, newColumnName, lowerHeaderRowNumber, newColumnName, lowerHeaderRowNumber)
-	values.Range = likesUpdateCell
-
-	updateCall = srv.Spreadsheets.Values.Update(spreadSheetID, likesUpdateCell, &values)
-	updateCall.ValueInputOption("USER_ENTERED")
-
-	_, err = updateCall.Do()
+	if err != nil {
+		return err
+	}
 
 	secondSectionCopyPasteRequestTop := sheets.Request{
 		CopyPaste: &sheets.CopyPasteRequest{
@@ -439,67 +505,76 @@ func spreadSheetWork(srv *sheets.Service, newSheetName string, oldSheetName stri
 		},
 	}
 
-	followerReadRange := fmt.Sprintf("%s!B%d:B%d", newSheet.Title, upperHeaderRowNumber+1, upperHeaderRowNumber+numberOfAccounts)
-	followerReadResp, err := srv.Spreadsheets.Values.Get(spreadSheetID, followerReadRange).Do()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if len(followerReadResp.Values) == 0 {
-		fmt.Println("COULDN'T FIND FOLLOWERS")
-	} else {
-		for i, row := range followerReadResp.Values {
-			platform := row[0]
-			for j, accObj := range accounts {
-				if accObj.Platform == platform {
-					accObj.SheetRowNum = j
-					var values sheets.ValueRange
-					// Followers
-					value := []interface{}{accObj.Followers}
-					values.Values = append(values.Values, value)
-					values.MajorDimension = "ROWS"
+	// Insert and hide in one batch, then shift last week's comparison column
+	// along before this week's values land.
+	requests = []*sheets.Request{}
+	requests = append(requests, &insertColumnFirstSectionRequest)
+	requests = append(requests, &hideColumnRequest)
+	requests = append(requests, &secondSectionCopyPasteRequestTop)
+	requests = append(requests, &secondSectionCopyPasteRequestBottom)
 
-					followersUpdateCell := newSheet.Title + "!" + newColumnName + strconv.Itoa(i+3) + ":" + newColumnName + strconv.Itoa(i+3)
+	if _, err := writer.BatchUpdate(ctx, requests); err != nil {
+		return fmt.Errorf("inserting column: %v", err)
+	}
+	run.done("insert column")
 
-					values.Range = followersUpdateCell
+	// Find which row each account is on in both sections
+	followerRows, likeRows, err := readAccountLabels(ctx, srv, spreadSheetID, labelSheetTitle, upperHeaderRowNumber, lowerHeaderRowNumber, numberOfAccounts)
+	if err != nil {
+		return err
+	}
 
-					updateCall := srv.Spreadsheets.Values.Update(spreadSheetID, followersUpdateCell, &values)
-					updateCall.ValueInputOption("USER_ENTERED")
+	// Header Values
+	data := []*sheets.ValueRange{
+		cellValue(newSheet.Title, newColumnName, upperHeaderRowNumber, dateFormat),
+		cellValue(newSheet.Title, newColumnName, lowerHeaderRowNumber, dateFormat),
+	}
 
-					_, err = updateCall.Do()
+	// Followers
+	if len(followerRows) == 0 {
+		fmt.Println("COULDN'T FIND FOLLOWERS")
+	}
+	for i, row := range followerRows {
+		if len(row) == 0 {
+			continue
+		}
+		for j, accObj := range accounts {
+			if accObj.Platform == row[0] {
+				accObj.SheetRowNum = j
+				// Left blank until after the sort, which puts blanks last.
+				var value interface{} = accObj.Followers
+				if accObj.CaptureErr != nil {
+					value = ""
 				}
+				data = append(data, cellValue(newSheet.Title, newColumnName, upperHeaderRowNumber+1+int64(i), value))
 			}
 		}
 	}
-	//likesReadRange := newSheet.Title + "!" + "B30:B53"
-	likesReadRange := fmt.Sprintf("%s!B%d:B%d", newSheet.Title, lowerHeaderRowNumber+1, lowerHeaderRowNumber+numberOfAccounts)
-	likesReadResp, err := srv.Spreadsheets.Values.Get(spreadSheetID, likesReadRange).Do()
-	if len(likesReadResp.Values) == 0 {
+
+	// Likes
+	if len(likeRows) == 0 {
 		fmt.Println("COULDN'T FIND LIKES")
-	} else {
-		for i, row := range likesReadResp.Values {
-			paltform := row[0]
-			for _, accObj := range accounts {
-				if accObj.Platform == paltform {
-					var values sheets.ValueRange
-					// Likes
-					values = sheets.ValueRange{}
-					value = []interface{}{accObj.Likes}
-					values.Values = append(values.Values, value)
-					values.MajorDimension = "ROWS"
-					cellnumber := i + int(lowerHeaderRowNumber) + 1
-					likesUpdateCell := newSheet.Title + "!" + newColumnName + strconv.Itoa(cellnumber) + ":" + newColumnName + strconv.Itoa(cellnumber)
-
-					values.Range = likesUpdateCell
-
-					updateCall = srv.Spreadsheets.Values.Update(spreadSheetID, likesUpdateCell, &values)
-					updateCall.ValueInputOption("USER_ENTERED")
-
-					_, err = updateCall.Do()
+	}
+	for i, row := range likeRows {
+		if len(row) == 0 {
+			continue
+		}
+		for _, accObj := range accounts {
+			if accObj.Platform == row[0] {
+				var value interface{} = accObj.Likes
+				if accObj.CaptureErr != nil {
+					value = ""
 				}
+				data = append(data, cellValue(newSheet.Title, newColumnName, lowerHeaderRowNumber+1+int64(i), value))
 			}
 		}
 	}
 
+	if err := writer.WriteValues(ctx, data); err != nil {
+		return fmt.Errorf("writing values: %v", err)
+	}
+	run.done("write values")
+
 	mainCopyPasteRequestSecondTop := sheets.Request{
 		CopyPaste: &sheets.CopyPasteRequest{
 			PasteOrientation: "NORMAL",
@@ -572,134 +647,239 @@ func spreadSheetWork(srv *sheets.Service, newSheetName string, oldSheetName stri
 	}
 
 	requests = []*sheets.Request{}
-	requests = append(requests, &secondSectionCopyPasteRequestTop)
-	requests = append(requests, &secondSectionCopyPasteRequestBottom)
 	requests = append(requests, &mainCopyPasteRequestSecondTop)
 	requests = append(requests, &mainCopyPasteRequestSecondBottom)
 	requests = append(requests, &sortMainSectionFollowersRequest)
 	requests = append(requests, &sortMainSectionLikesRequest)
 
-	batchReq = &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: requests,
+	if _, err := writer.BatchUpdate(ctx, requests); err != nil {
+		return fmt.Errorf("copying and sorting: %v", err)
+	}
+	run.done("copy and sort")
+
+	// The first run to write changes adds their columns, with an empty one
+	// before them to set them apart from the dated blocks.
+	if !deltaFound {
+		var columnCount int64
+		if oldSheet.GridProperties != nil {
+			// One more now this run's column is in
+			columnCount = oldSheet.GridProperties.ColumnCount + 1
+		}
+		requests = []*sheets.Request{addColumnsRequest(newSheet.SheetId, deltaColumn-1, int64(len(deltaHeaders))+1, columnCount)}
+		if _, err := writer.BatchUpdate(ctx, requests); err != nil {
+			return fmt.Errorf("adding change columns: %v", err)
+		}
+		run.done("add change columns")
 	}
 
-	_, err = srv.Spreadsheets.BatchUpdate(spreadSheetID, batchReq).Do()
+	// The second block's copy was outside the sort, so failed accounts keep
+	// their old rows there.
+	copyColumnName, err := excelize.ColumnNumberToName(int(state.SecondBlockStart))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	copyStatus := statusValues(newSheet.Title, copyColumnName, upperHeaderRowNumber, lowerHeaderRowNumber, followerRows, likeRows, accounts)
+
+	// The sort moved the rows, so find them again before writing the changes.
+	// A dry run cannot sort, so its plan shows the rows as they were before.
+	followerRows, likeRows, err = readAccountLabels(ctx, srv, spreadSheetID, labelSheetTitle, upperHeaderRowNumber, lowerHeaderRowNumber, numberOfAccounts)
+	if err != nil {
+		return err
+	}
+	data = changeValues(newSheet.Title, deltaColumn, upperHeaderRowNumber, lowerHeaderRowNumber, followerRows, likeRows, accounts)
+	// Failed accounts now sit below every count, so their status can go in.
+	data = append(data, statusValues(newSheet.Title, newColumnName, upperHeaderRowNumber, lowerHeaderRowNumber, followerRows, likeRows, accounts)...)
+	data = append(data, copyStatus...)
+	if err := writer.WriteValues(ctx, data); err != nil {
+		return fmt.Errorf("writing changes: %v", err)
 	}
+	run.done("write changes")
+	return nil
 }
 
 func main() {
-	// Create screenshot directory
-	currentWD, err := os.Getwd()
-	errChk(err)
-	datePath := time.Now().Format("2006-01-02")
-	screenshotPath := filepath.Join(currentWD, screenshotDir, datePath)
-	if _, err := os.Stat(screenshotPath); os.IsNotExist(err) {
-		os.MkdirAll(screenshotPath, os.ModePerm)
-	}
-	// Setup Selenium
-	const (
-		seleniumURL = "http://192.168.1.3:4444/wd/hub"
-	)
-	caps := selenium.Capabilities{
-		"browserName": "chrome",
-	}
-	chromeOptions := chrome.Capabilities{
-		Args: []string{
-			//"--user-agent=Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.116 Safari/537.36",
-			"--user-agent=Applebot",
-			"--lang=en_US",
-			"--headless",
-			"--window-size=1920,1080",
-		},
-		ExcludeSwitches: []string{
-			"enable-automation",
-		},
+	command, args := run, os.Args[1:]
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			command, args = runHistory, os.Args[2:]
+		case "rebuild":
+			command, args = runRebuild, os.Args[2:]
+		}
 	}
-	caps.AddChrome(chromeOptions)
-	// Create the web driver
-	driver, err := selenium.NewRemote(caps, seleniumURL)
-	defer driver.Quit()
-	errChk(err)
+	// Only here, as log.Fatal skips the commands' deferred Closes.
+	if err := command(args); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// Sheets API setup
-	state, err := loadSaveStateFromFile("saveState-testing.json")
+// cogsworth [flags]
+//
+// A capture run. The browser sessions and the history database are closed
+// on the way out, whether it succeeds or not.
+func run(args []string) error {
+	cfg, _, err := loadConfig(flag.NewFlagSet("cogsworth", flag.ExitOnError), args)
+	if err == nil {
+		err = cfg.validate()
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	b, err := ioutil.ReadFile("credentials.json")
+	history, err := openHistoryStore(cfg.HistoryFile)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		return err
 	}
+	defer history.Close()
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, "https://www.googleapis.com/auth/spreadsheets", "https://www.googleapis.com/auth/drive", "https://www.googleapis.com/auth/drive.file")
+	proxies, err := newProxyPool(cfg)
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return err
+	}
+	fingerprints := newFingerprintSet(cfg)
+	var fetchers []pageFetcher
+	switch cfg.Fetcher {
+	case "selenium":
+		// Create screenshot directory
+		currentWD, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		datePath := time.Now().Format("2006-01-02")
+		screenshotPath := filepath.Join(currentWD, cfg.ScreenshotDir, datePath)
+		if _, err := os.Stat(screenshotPath); os.IsNotExist(err) {
+			os.MkdirAll(screenshotPath, os.ModePerm)
+		}
+		sessions := cfg.Sessions
+		if sessions == 0 {
+			sessions, err = gridCapacity(cfg.SeleniumURL)
+			if err != nil {
+				log.Printf("Unable to read the grid's capacity, using one session: %v", err)
+				sessions = 1
+			}
+		}
+		// Create the web drivers, carrying on with fewer if the grid is full
+		for i := 0; i < sessions; i++ {
+			fetcher, err := startSeleniumFetcher(cfg, screenshotPath, proxies, fingerprints)
+			if err != nil && i == 0 {
+				return err
+			}
+			if err != nil {
+				log.Printf("Started %d of %d sessions: %v", i, sessions, err)
+				break
+			}
+			defer fetcher.Close()
+			fetchers = append(fetchers, fetcher)
+		}
+	case "http":
+		// Each worker keeps its own proxy and fingerprint, as a browser
+		// session would.
+		workers := cfg.Sessions
+		if workers == 0 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			fetchers = append(fetchers, newHTTPFetcher(proxies, fingerprints))
+		}
 	}
-	client := getClient(config)
 
-	srv, err := sheets.New(client)
-	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+	var srv *sheets.Service
+	if cfg.usesSheets() {
+		srv, err = newSheetsService(cfg)
+		if err != nil {
+			return err
+		}
 	}
+	return runCapture(cfg, srv, fetchers, history, clock())
+}
 
+// Capture every account and write the results where the config says. This
+// is everything a run does once the services are connected, so tests can run
+// it against fakes of them.
+func runCapture(cfg *Config, srv *sheets.Service, fetchers []pageFetcher, history *HistoryStore, currentDate time.Time) error {
+	var err error
+	stateStore := newStateStore(cfg.StateFile)
+	state := &UpdateState{}
+	if cfg.Output == "sheets" {
+		state, err = stateStore.Load()
+		if err != nil {
+			return fmt.Errorf("Unable to load save state: %v", err)
+		}
+	}
 	// Let's find how many accounts we're dealing with today
-	urlSpreadsheetID := "1GRXYwIcmA2fQbOqr4ihO_4MY9Ok80-Su7ib2B7YV1lo"
-	urlSheetName := "TikTok URLs!"
-	urlRanges := urlSheetName + "A1:C256"
-	batchGetCall := srv.Spreadsheets.Values.Get(urlSpreadsheetID, urlRanges)
-	batchGetCall = batchGetCall.MajorDimension("COLUMNS")
-
-	resp, err := batchGetCall.Do()
-
+	var accounts []*Account
+	if cfg.AccountsFile != "" {
+		accounts, err = readAccountsFile(cfg.AccountsFile)
+	} else {
+		urlCtx, cancel := context.WithTimeout(context.Background(), cfg.SheetsDeadline.Duration)
+		defer cancel()
+		accounts, err = readAccounts(urlCtx, srv, cfg)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	lookingForSpace := false
-	accounts := []*Account{}
-	for rowNum, row := range resp.Values[0] {
-		rowValue := fmt.Sprintf("%v", row)
-		if rowValue == "1" {
-			lookingForSpace = true
+	// Read in the URLs
+	y, m, d := currentDate.Date()
+	usedToday, err := history.CountsByPlatform(time.Date(y, m, d, 0, 0, 0, 0, currentDate.Location()))
+	if err != nil {
+		return err
+	}
+	limiter := newRateLimiter(cfg, usedToday)
+	failed := captureAll(accounts, fetchers, cfg.AccountTimeout.Duration, limiter)
+	log.Print(captureSummary(accounts))
+	// Accounts refused before a fetch still need a time, or their records
+	// would sort after every real capture in the history.
+	for _, account := range accounts {
+		if account.CapturedAt.IsZero() {
+			account.CapturedAt = currentDate
 		}
-		if lookingForSpace {
-			fullURL := fmt.Sprintf("%s", resp.Values[2][rowNum])
-			accountNumber, _ := strconv.Atoi(rowValue)
-			newAccount := &Account{
-				CountNum:    accountNumber,
-				SheetRowNum: rowNum + 1,
-				Platform:    fmt.Sprintf("%s", resp.Values[1][rowNum]),
-				AccountName: fullURL[strings.Index(fullURL, "@")+1 : len(fullURL)],
-				FullURL:     fullURL,
+	}
+	// A dry run leaves the history and exports alone as well as the sheet.
+	if err := computeChanges(history, accounts); err != nil {
+		log.Printf("Unable to work out changes since earlier runs: %v", err)
+	}
+	if !cfg.DryRun {
+		for _, account := range accounts {
+			if err := history.Record(captureFromAccount(account)); err != nil {
+				log.Printf("Unable to record history for %s: %v", account.AccountName, err)
 			}
-			accounts = append(accounts, newAccount)
 		}
-		if lookingForSpace && rowValue == "" {
-			break
+	}
+	if cfg.ExportDir != "" && !cfg.DryRun {
+		if err := exportAccounts(cfg.ExportDir, currentDate, accounts); err != nil {
+			log.Printf("Unable to export this run's captures: %v", err)
 		}
 	}
-
-	// Read in the URLs
-	for _, account := range accounts {
-		captureData(account, driver, screenshotPath)
+	if failed == len(accounts) {
+		return fmt.Errorf("Unable to capture any of the %d accounts", len(accounts))
 	}
 
 	// Time to go to work!
-	currentDate := time.Now()
-	dateFormat := currentDate.Format("01/02/2006")
-	dayOfWeek := currentDate.Weekday()
-	prevDate := time.Now().AddDate(0, 0, -7)
-	oldSheetName := prevDate.Format("01/02/2006") + " (" + prevDate.Weekday().String()[:1] + ")"
-	newSheetName := dateFormat + " (" + dayOfWeek.String()[:1] + ")"
-	// TESTING
-	//oldSheetName = "08/04/2020 (T)"
-	//newSheetName = "TEST"
-	spreadSheetWork(srv, newSheetName, oldSheetName, dateFormat, state, accounts)
+	if cfg.Output == "xlsx" {
+		if cfg.DryRun {
+			fmt.Printf("Dry run; would write %s\n", cfg.XLSXFile)
+			return nil
+		}
+		if err := writeWorkbook(cfg.XLSXFile, currentDate, history, accounts); err != nil {
+			return fmt.Errorf("Unable to write %s: %v", cfg.XLSXFile, err)
+		}
+		return nil
+	}
+	sheetsCtx, cancelSheets := context.WithTimeout(context.Background(), cfg.SheetsDeadline.Duration)
+	defer cancelSheets()
+	if err := spreadSheetWork(sheetsCtx, srv, cfg, currentDate, state, accounts); err != nil {
+		return fmt.Errorf("Unable to update the spreadsheet: %v", err)
+	}
+	if cfg.DryRun {
+		return nil
+	}
+	// The next run's blocks are one on from the ones this run used.
 	state.FirstBlockStart++
 	state.SecondBlockStart++
 	state.ThirdBlockStart++
-	saveSaveStateToFile("saveState.json", state)
+	state.LastRunDate = currentDate.Format("2006-01-02")
+	if err := stateStore.Save(state); err != nil {
+		return fmt.Errorf("Unable to save state to %s: %v", cfg.StateFile, err)
+	}
+	return nil
 }
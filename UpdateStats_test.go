@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	testStatsID = "stats-spreadsheet"
+	testURLsID  = "urls-spreadsheet"
+)
+
+// The Sunday the tests run on, and the tab from the week before it.
+var (
+	testRunDate  = time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	testPrevious = "10/11/2026 (S)"
+	testNew      = "10/18/2026 (S)"
+)
+
+// Everything runCapture needs, wired to fakes.
+type testRun struct {
+	cfg     *Config
+	fake    *fakeSheets
+	driver  *fakeWebDriver
+	history *HistoryStore
+}
+
+// Three TikTok accounts: alpha and beta have stats in their fixtures (in the
+// current and the older embedded JSON) and gamma is private. Last week's tab
+// has the follower block in C:E, last week and this week in G:H and the
+// change block from J, for the followers in rows 2-5 and the likes in 7-10.
+func newTestRun(t *testing.T) *testRun {
+	clock = func() time.Time { return testRunDate }
+	t.Cleanup(func() { clock = time.Now })
+	dir := t.TempDir()
+	fake := newFakeSheets(t)
+	fake.addTab(testURLsID, "TikTok URLs", [][]interface{}{
+		{"#", "Label", "URL"},
+		{"1", "Alpha", "https://www.tiktok.com/@alpha"},
+		{"2", "Beta", "https://www.tiktok.com/@beta"},
+		{"3", "Gamma", "https://www.tiktok.com/@gamma"},
+	})
+	header := []interface{}{"", "Account", "09/27/2026", "10/04/2026", "10/11/2026", "", "10/04/2026", "10/11/2026", ""}
+	for _, h := range deltaHeaders {
+		header = append(header, h)
+	}
+	fake.addTab(testStatsID, testPrevious, [][]interface{}{
+		{},
+		header,
+		{"1", "Alpha", 900.0, 950.0, 1000.0, "", 950.0, 1000.0},
+		{"2", "Beta", 3000.0, 3100.0, 3150.0, "", 3100.0, 3150.0},
+		{"3", "Gamma", 10.0, 20.0, 30.0, "", 20.0, 30.0},
+		{},
+		header,
+		{"1", "Alpha", 14000.0, 14500.0, 15000.0, "", 14500.0, 15000.0},
+		{"2", "Beta", 8000.0, 8500.0, 8800.0, "", 8500.0, 8800.0},
+		{"3", "Gamma", 100.0, 200.0, 300.0, "", 200.0, 300.0},
+	})
+
+	driver := newFakeWebDriver()
+	for _, name := range []string{"alpha", "beta", "gamma"} {
+		driver.serveFixture(t, "https://www.tiktok.com/@"+name, "tiktok/"+name+".html")
+	}
+
+	cfg := defaultConfig()
+	cfg.SpreadsheetID = testStatsID
+	cfg.URLSpreadsheetID = testURLsID
+	cfg.StateFile = filepath.Join(dir, "saveState.json")
+	cfg.HistoryFile = filepath.Join(dir, "history.db")
+	cfg.ScreenshotDir = filepath.Join(dir, "screenshots")
+	cfg.RequestsPerMinute = 0
+	cfg.FetchJitter = Duration{}
+	state := &UpdateState{Version: stateVersion, FirstBlockStart: 5, SecondBlockStart: 9, ThirdBlockStart: 10}
+	if err := newStateStore(cfg.StateFile).Save(state); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := openHistoryStore(cfg.HistoryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+	// Alpha has a capture from last week's run and one from four weeks ago.
+	for _, c := range []Capture{
+		{Account: "alpha", Platform: "tiktok", Label: "Alpha", CapturedAt: testRunDate.AddDate(0, 0, -28), Followers: 800, Likes: 12000},
+		{Account: "alpha", Platform: "tiktok", Label: "Alpha", CapturedAt: testRunDate.AddDate(0, 0, -7), Followers: 1000, Likes: 15000},
+	} {
+		if err := history.Record(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &testRun{cfg: cfg, fake: fake, driver: driver, history: history}
+}
+
+func (r *testRun) run() error {
+	fetcher := &seleniumFetcher{driver: r.driver, screenshotPath: r.cfg.ScreenshotDir}
+	os.MkdirAll(r.cfg.ScreenshotDir, os.ModePerm)
+	return runCapture(r.cfg, r.fake.service(), []pageFetcher{fetcher}, r.history, testRunDate)
+}
+
+func expectCells(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func TestRunCaptureUpdatesSheet(t *testing.T) {
+	r := newTestRun(t)
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious, testNew)
+	tab := r.fake.tab(testStatsID, testNew)
+
+	// This week's column went in at F and the block was sorted on it. The
+	// failed account's status goes in after the sort, so it stays last.
+	expectCells(t, "followers header", []string{tab.cell("E2"), tab.cell("F2")}, "10/11/2026", "10/18/2026")
+	expectCells(t, "follower labels", tab.column("B", 3, 5), "Beta", "Alpha", "Gamma")
+	expectCells(t, "followers", tab.column("F", 3, 5), "3200", "1500", "ERROR: private")
+	expectCells(t, "likes header", []string{tab.cell("F7")}, "10/18/2026")
+	expectCells(t, "like labels", tab.column("B", 8, 10), "Alpha", "Beta", "Gamma")
+	expectCells(t, "likes", tab.column("F", 8, 10), "20000", "9000", "ERROR: private")
+	if !tab.hidden[4] {
+		t.Errorf("column E is not hidden")
+	}
+
+	// Last week's comparison column moved along and this week's was copied in.
+	expectCells(t, "last week", tab.column("H", 2, 5), "10/11/2026", "1000", "3150", "30")
+	expectCells(t, "this week", tab.column("I", 2, 5), "10/18/2026", "1500", "3200", "ERROR: private")
+
+	// The change block shifted to K and matches the sorted labels.
+	expectCells(t, "change headers", []string{tab.cell("K2"), tab.cell("L2"), tab.cell("M2"), tab.cell("N2")}, deltaHeaders...)
+	expectCells(t, "alpha follower changes", []string{tab.cell("K4"), tab.cell("L4"), tab.cell("M4"), tab.cell("N4")}, "500", "50.00%", "700", "87.50%")
+	expectCells(t, "beta follower changes", []string{tab.cell("K3"), tab.cell("L3")}, "", "")
+	expectCells(t, "alpha like changes", []string{tab.cell("K8"), tab.cell("L8"), tab.cell("M8"), tab.cell("N8")}, "5000", "33.33%", "8000", "66.67%")
+
+	// Last week's tab is untouched.
+	expectCells(t, "previous followers", r.fake.tab(testStatsID, testPrevious).column("E", 3, 5), "1000", "3150", "30")
+
+	b, err := ioutil.ReadFile(r.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state UpdateState
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	want := UpdateState{Version: stateVersion, FirstBlockStart: 6, SecondBlockStart: 10, ThirdBlockStart: 11, LastRunDate: "2026-10-18"}
+	if state != want {
+		t.Errorf("saved state = %+v, want %+v", state, want)
+	}
+
+	captures, err := r.history.History("tiktok", "alpha", testRunDate.AddDate(0, 0, -1), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 || captures[0].Followers != 1500 || captures[0].Likes != 20000 {
+		t.Errorf("recorded %+v, want one capture of 1500 followers and 20000 likes", captures)
+	}
+	captures, err = r.history.History("tiktok", "gamma", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 1 || captures[0].Status != ScrapePrivate.String() {
+		t.Errorf("recorded %+v for gamma, want one private capture", captures)
+	}
+	if _, err := os.Stat(filepath.Join(r.cfg.ScreenshotDir, "alpha.png")); err != nil {
+		t.Errorf("no screenshot: %v", err)
+	}
+}
+
+func TestRunCaptureDryRunChangesNothing(t *testing.T) {
+	r := newTestRun(t)
+	r.cfg.DryRun = true
+	before, err := ioutil.ReadFile(r.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious)
+	after, err := ioutil.ReadFile(r.cfg.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("save state changed from %s to %s", before, after)
+	}
+	captures, err := r.history.History("tiktok", "beta", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 0 {
+		t.Errorf("dry run recorded %+v", captures)
+	}
+}
+
+func TestRunCaptureRollsBackFailedRun(t *testing.T) {
+	r := newTestRun(t)
+	r.fake.failRequest = func(req *sheets.Request) bool { return req.SortRange != nil }
+	if err := r.run(); err == nil {
+		t.Fatal("run succeeded despite the sort failing")
+	}
+
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious)
+	state, err := newStateStore(r.cfg.StateFile).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.FirstBlockStart != 5 || state.LastRunDate != "" {
+		t.Errorf("state advanced after a failed run: %+v", state)
+	}
+}
+
+// A duplicate that went through but whose response was lost must be neither
+// sent again nor left behind.
+func TestRunCaptureRollsBackAmbiguousDuplicate(t *testing.T) {
+	r := newTestRun(t)
+	r.fake.loseResponse = func(req *sheets.Request) bool { return req.DuplicateSheet != nil }
+	if err := r.run(); err == nil {
+		t.Fatal("run succeeded despite the duplicate failing")
+	}
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious)
+}
+
+// Today's tab from an earlier run is left alone, even when the duplicate
+// fails in a way that might have created it.
+func TestRunCaptureKeepsExistingTab(t *testing.T) {
+	r := newTestRun(t)
+	r.fake.addTab(testStatsID, testNew, [][]interface{}{{}, {"", "Account", "10/18/2026"}})
+	r.fake.loseResponse = func(req *sheets.Request) bool { return req.DuplicateSheet != nil }
+	if err := r.run(); err == nil {
+		t.Fatal("run succeeded with today's tab already there")
+	}
+	expectCells(t, "tabs", r.fake.titles(testStatsID), testPrevious, testNew)
+	expectCells(t, "existing header", []string{r.fake.tab(testStatsID, testNew).cell("C2")}, "10/18/2026")
+}
+
+// A template whose third dated block is followed by columns of its own must
+// keep them; the changes go in new columns to the right of everything.
+func TestRunCaptureKeepsThirdBlock(t *testing.T) {
+	r := newTestRun(t)
+	header := []interface{}{"", "Account", "09/27/2026", "10/04/2026", "10/11/2026", "", "10/04/2026", "10/11/2026", "", "10/04/2026", "10/11/2026", "Diff", "Notes"}
+	r.fake.tab(testStatsID, testPrevious).cells = [][]interface{}{
+		{},
+		header,
+		{"1", "Alpha", 900.0, 950.0, 1000.0, "", 950.0, 1000.0, "", 950.0, 1000.0, "50", "steady"},
+		{"2", "Beta", 3000.0, 3100.0, 3150.0, "", 3100.0, 3150.0, "", 3100.0, 3150.0, "50", "new campaign"},
+		{"3", "Gamma", 10.0, 20.0, 30.0, "", 20.0, 30.0, "", 20.0, 30.0, "10", ""},
+		{},
+		header,
+		{"1", "Alpha", 14000.0, 14500.0, 15000.0, "", 14500.0, 15000.0, "", 14500.0, 15000.0, "500", ""},
+		{"2", "Beta", 8000.0, 8500.0, 8800.0, "", 8500.0, 8800.0, "", 8500.0, 8800.0, "300", ""},
+		{"3", "Gamma", 100.0, 200.0, 300.0, "", 200.0, 300.0, "", 200.0, 300.0, "100", ""},
+	}
+	state := &UpdateState{Version: stateVersion, FirstBlockStart: 5, SecondBlockStart: 9, ThirdBlockStart: 12}
+	if err := newStateStore(r.cfg.StateFile).Save(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The third block and its columns moved along one with this week's
+	// column and are otherwise untouched.
+	tab := r.fake.tab(testStatsID, testNew)
+	expectCells(t, "third block header", []string{tab.cell("K2"), tab.cell("L2"), tab.cell("M2"), tab.cell("N2")}, "10/04/2026", "10/11/2026", "Diff", "Notes")
+	expectCells(t, "notes", tab.column("N", 3, 5), "steady", "new campaign", "")
+	expectCells(t, "like diffs", tab.column("M", 8, 10), "500", "300", "100")
+
+	// The changes start after an empty column and line up with the sorted labels.
+	expectCells(t, "gap", []string{tab.cell("O2"), tab.cell("O7")}, "", "")
+	expectCells(t, "change headers", []string{tab.cell("P2"), tab.cell("Q2"), tab.cell("R2"), tab.cell("S2")}, deltaHeaders...)
+	expectCells(t, "follower labels", tab.column("B", 3, 5), "Beta", "Alpha", "Gamma")
+	expectCells(t, "alpha follower changes", []string{tab.cell("P4"), tab.cell("Q4"), tab.cell("R4"), tab.cell("S4")}, "500", "50.00%", "700", "87.50%")
+	expectCells(t, "like change header", []string{tab.cell("P7")}, deltaHeaders[0])
+
+	saved, err := newStateStore(r.cfg.StateFile).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ThirdBlockStart != 13 {
+		t.Errorf("third block saved at %d, want 13", saved.ThirdBlockStart)
+	}
+}
+
+// Rerunning the same day after deleting the tab reads the same offsets off
+// last week's tab and must save the same state as the first run did.
+func TestRunCaptureRerunSameDayKeepsState(t *testing.T) {
+	r := newTestRun(t)
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	srv := r.fake.service()
+	deleteTab := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{
+		{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: r.fake.tab(testStatsID, testNew).id}},
+	}}
+	if _, err := srv.Spreadsheets.BatchUpdate(testStatsID, deleteTab).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := newStateStore(r.cfg.StateFile).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UpdateState{Version: stateVersion, FirstBlockStart: 6, SecondBlockStart: 10, ThirdBlockStart: 11, LastRunDate: "2026-10-18"}
+	if *state != want {
+		t.Errorf("saved state after rerun = %+v, want %+v", *state, want)
+	}
+}
+
+// A rerun that cannot read the headers falls back to the save state, which
+// the first run already advanced, and must still write where that run did.
+func TestRunCaptureRerunSameDayFromSaveState(t *testing.T) {
+	r := newTestRun(t)
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+	srv := r.fake.service()
+	deleteTab := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{
+		{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: r.fake.tab(testStatsID, testNew).id}},
+	}}
+	if _, err := srv.Spreadsheets.BatchUpdate(testStatsID, deleteTab).Do(); err != nil {
+		t.Fatal(err)
+	}
+	// Without dates in the follower header the blocks cannot be found.
+	clearDates := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: []*sheets.ValueRange{
+		{Range: testPrevious + "!C2:H2", Values: [][]interface{}{{"", "", "", "", "", ""}}},
+	}}
+	if _, err := srv.Spreadsheets.Values.BatchUpdate(testStatsID, clearDates).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tab := r.fake.tab(testStatsID, testNew)
+	expectCells(t, "followers header", []string{tab.cell("F2"), tab.cell("G2")}, "10/18/2026", "")
+	expectCells(t, "this week", tab.column("I", 3, 5), "1500", "3200", "ERROR: private")
+	state, err := newStateStore(r.cfg.StateFile).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UpdateState{Version: stateVersion, FirstBlockStart: 6, SecondBlockStart: 10, ThirdBlockStart: 11, LastRunDate: "2026-10-18"}
+	if *state != want {
+		t.Errorf("saved state after rerun = %+v, want %+v", *state, want)
+	}
+}
+
+// Accounts the daily cap turned away were never fetched, so they must not
+// use up the next day's cap.
+func TestRunCaptureDailyCapResetsNextDay(t *testing.T) {
+	r := newTestRun(t)
+	r.cfg.Output = "xlsx"
+	r.cfg.XLSXFile = filepath.Join(t.TempDir(), "cogsworth.xlsx")
+	r.cfg.DailyCap = 2
+	for _, day := range []time.Time{testRunDate, testRunDate.AddDate(0, 0, 1)} {
+		clock = func() time.Time { return day }
+		fetcher := &seleniumFetcher{driver: r.driver, screenshotPath: r.cfg.ScreenshotDir}
+		if err := runCapture(r.cfg, r.fake.service(), []pageFetcher{fetcher}, r.history, day); err != nil {
+			t.Fatal(err)
+		}
+
+		var statuses []string
+		for _, account := range []string{"alpha", "beta", "gamma"} {
+			captures, err := r.history.History("tiktok", account, day, day.AddDate(0, 0, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(captures) != 1 {
+				t.Fatalf("%s: %d captures for %s, want 1", day.Format("2006-01-02"), len(captures), account)
+			}
+			statuses = append(statuses, captures[0].Status)
+		}
+		expectCells(t, day.Format("2006-01-02")+" statuses", statuses, "", "", ScrapeCapReached.String())
+
+		counts, err := r.history.CountsByPlatform(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts["tiktok"] != 2 {
+			t.Errorf("%s: %d tiktok fetches counted, want 2", day.Format("2006-01-02"), counts["tiktok"])
+		}
+	}
+}
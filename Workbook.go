@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	// Excel Functions
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+// Fill colours the workbook marks gains and losses with.
+const (
+	gainStyle = `{"font":{"color":"#006100"},"fill":{"type":"pattern","color":["#C6EFCE"],"pattern":1}}`
+	lossStyle = `{"font":{"color":"#9C0006"},"fill":{"type":"pattern","color":["#FFC7CE"],"pattern":1}}`
+)
+
+// One account's good captures, by day (2006-01-02).
+type dailyCounts map[string]Capture
+
+// Write this run's stats to a local workbook laid out like the run's tab in
+// the Google Sheet: a column per run date with followers on top and likes
+// below, each section sorted by this run's count, then the change columns.
+// Earlier dates come from the history store, so the workbook is rebuilt in
+// full every run.
+func writeWorkbook(path string, runDate time.Time, history *HistoryStore, accounts []*Account) error {
+	days := map[string]bool{}
+	counts := make([]dailyCounts, len(accounts))
+	for i, account := range accounts {
+		platform, _ := platformForURL(account.FullURL)
+		captures, err := history.History(platform, account.AccountName, time.Time{}, time.Time{})
+		if err != nil {
+			return err
+		}
+		counts[i] = dailyCounts{}
+		for _, c := range captures {
+			if c.Status != "" {
+				continue
+			}
+			day := c.CapturedAt.Local().Format("2006-01-02")
+			counts[i][day] = c
+			days[day] = true
+		}
+	}
+	today := runDate.Format("2006-01-02")
+	days[today] = true
+	var dates []string
+	for day := range days {
+		dates = append(dates, day)
+	}
+	sort.Strings(dates)
+	todayColumn := 3 + sort.SearchStrings(dates, today)
+
+	sheet := today
+	f := excelize.NewFile()
+	f.SetSheetName("Sheet1", sheet)
+
+	numberOfAccounts := len(accounts)
+	upperHeaderRowNumber := 2
+	lowerHeaderRowNumber := upperHeaderRowNumber + numberOfAccounts + 2
+	// Account labels in B, one column per date from C, a gap, then changes
+	deltaColumn := 3 + len(dates) + 1
+
+	sections := []struct {
+		title     string
+		headerRow int
+		count     func(c Capture) int64
+		current   func(a *Account) int64
+		change    func(a *Account, week bool) Change
+	}{
+		{"Followers", upperHeaderRowNumber,
+			func(c Capture) int64 { return c.Followers },
+			func(a *Account) int64 { return int64(a.Followers) },
+			func(a *Account, week bool) Change {
+				if week {
+					return a.WeekChange.Followers
+				}
+				return a.FourWeekChange.Followers
+			}},
+		{"Likes", lowerHeaderRowNumber,
+			func(c Capture) int64 { return c.Likes },
+			func(a *Account) int64 { return int64(a.Likes) },
+			func(a *Account, week bool) Change {
+				if week {
+					return a.WeekChange.Likes
+				}
+				return a.FourWeekChange.Likes
+			}},
+	}
+
+	percentStyle, err := f.NewStyle(`{"number_format":10}`)
+	if err != nil {
+		return err
+	}
+	gain, err := f.NewConditionalStyle(gainStyle)
+	if err != nil {
+		return err
+	}
+	loss, err := f.NewConditionalStyle(lossStyle)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range sections {
+		f.SetCellValue(sheet, cellName(2, section.headerRow-1), section.title)
+		f.SetCellValue(sheet, cellName(2, section.headerRow), "Account")
+		for i, day := range dates {
+			date, _ := time.Parse("2006-01-02", day)
+			f.SetCellValue(sheet, cellName(3+i, section.headerRow), date.Format(tabDateLayout))
+		}
+		for i, header := range deltaHeaders {
+			f.SetCellValue(sheet, cellName(deltaColumn+i, section.headerRow), header)
+		}
+
+		// Highest count this run first; accounts that failed go last.
+		order := make([]int, numberOfAccounts)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			a, b := accounts[order[i]], accounts[order[j]]
+			if (a.CaptureErr == nil) != (b.CaptureErr == nil) {
+				return a.CaptureErr == nil
+			}
+			return section.current(a) > section.current(b)
+		})
+
+		for rank, index := range order {
+			account := accounts[index]
+			row := section.headerRow + 1 + rank
+			f.SetCellValue(sheet, cellName(1, row), rank+1)
+			f.SetCellValue(sheet, cellName(2, row), account.Platform)
+			for i, day := range dates {
+				if c, ok := counts[index][day]; ok {
+					f.SetCellValue(sheet, cellName(3+i, row), section.count(c))
+				}
+			}
+			if account.CaptureErr != nil {
+				f.SetCellValue(sheet, cellName(todayColumn, row), account.CaptureErr.Status())
+			}
+			for i, week := range []bool{true, false} {
+				change := section.change(account, week)
+				if !change.Valid {
+					continue
+				}
+				column := deltaColumn + 2*i
+				f.SetCellValue(sheet, cellName(column, row), change.Absolute)
+				f.SetCellValue(sheet, cellName(column+1, row), change.Percent/100)
+				f.SetCellStyle(sheet, cellName(column+1, row), cellName(column+1, row), percentStyle)
+			}
+		}
+
+		if numberOfAccounts > 0 {
+			area := cellName(deltaColumn, section.headerRow+1) + ":" +
+				cellName(deltaColumn+len(deltaHeaders)-1, section.headerRow+numberOfAccounts)
+			format := fmt.Sprintf(`[{"type":"cell","criteria":">","format":%d,"value":"0"},{"type":"cell","criteria":"<","format":%d,"value":"0"}]`, gain, loss)
+			if err := f.SetConditionalFormat(sheet, area, format); err != nil {
+				return err
+			}
+		}
+	}
+
+	last, _ := excelize.ColumnNumberToName(deltaColumn + len(deltaHeaders) - 1)
+	f.SetColWidth(sheet, "B", "B", 24)
+	f.SetColWidth(sheet, "C", last, 14)
+	return f.SaveAs(path)
+}
+
+// A1 name of a 1-based column and row.
+func cellName(column int, row int) string {
+	name, _ := excelize.CoordinatesToCellName(column, row)
+	return name
+}
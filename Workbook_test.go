@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
+)
+
+func TestWriteWorkbook(t *testing.T) {
+	r := newTestRun(t)
+	r.cfg.Output = "xlsx"
+	r.cfg.XLSXFile = filepath.Join(t.TempDir(), "cogsworth.xlsx")
+	if err := r.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenFile(r.cfg.XLSXFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := "2026-10-18"
+	cells := func(axes ...string) []string {
+		var values []string
+		for _, axis := range axes {
+			v, err := f.GetCellValue(sheet, axis)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, v)
+		}
+		return values
+	}
+
+	// Alpha's earlier captures give a column each, then a gap before the
+	// changes.
+	expectCells(t, "follower header", cells("B1", "B2", "C2", "D2", "E2", "F2", "G2", "H2", "I2", "J2"),
+		"Followers", "Account", "09/20/2026", "10/11/2026", "10/18/2026", "",
+		"Change (week)", "Change % (week)", "Change (4 weeks)", "Change % (4 weeks)")
+	expectCells(t, "like header", cells("B6", "B7", "E7", "G7"), "Likes", "Account", "10/18/2026", "Change (week)")
+
+	// Highest first, with the account that failed last in both sections.
+	expectCells(t, "follower ranks", cells("A3", "A4", "A5"), "1", "2", "3")
+	expectCells(t, "follower labels", cells("B3", "B4", "B5"), "Beta", "Alpha", "Gamma")
+	expectCells(t, "followers", cells("E3", "E4", "E5"), "3200", "1500", "ERROR: private")
+	expectCells(t, "like labels", cells("B8", "B9", "B10"), "Alpha", "Beta", "Gamma")
+	expectCells(t, "likes", cells("E8", "E9", "E10"), "20000", "9000", "ERROR: private")
+	expectCells(t, "alpha history", cells("C4", "D4"), "800", "1000")
+
+	// Only alpha has earlier captures to change from.
+	expectCells(t, "alpha follower changes", cells("G4", "H4", "I4", "J4"), "500", "50.00%", "700", "87.50%")
+	expectCells(t, "beta follower changes", cells("G3", "H3", "I3", "J3"), "", "", "", "")
+	expectCells(t, "alpha like changes", cells("G8", "I8"), "5000", "8000")
+
+	// Gains and losses are coloured over the change columns of each section.
+	z, err := zip.OpenReader(r.cfg.XLSXFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	var worksheet string
+	for _, file := range z.File {
+		if file.Name == "xl/worksheets/sheet1.xml" {
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			worksheet = string(b)
+		}
+	}
+	for _, area := range []string{`sqref="G3:J5"`, `sqref="G8:J10"`} {
+		if !strings.Contains(worksheet, area) {
+			t.Errorf("no conditional format with %s", area)
+		}
+	}
+}